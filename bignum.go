@@ -1,6 +1,30 @@
 package smp
 
-import "math/big"
+import (
+	"crypto/subtle"
+	"math/big"
+)
+
+// ExpBackend is an implementation of modular exponentiation
+type ExpBackend int
+
+const (
+	// ConstantTimeExp uses a fixed-window Montgomery exponentiation over
+	// fixed-width limbs, whose running time does not depend on the exponent
+	ConstantTimeExp ExpBackend = iota
+	// VariableTimeExp uses math/big, which is faster but leaks the exponent
+	// through timing
+	VariableTimeExp
+)
+
+// SecretExpBackend selects the implementation used by every exponentiation
+// with a secret exponent. Exponentiations with public exponents (like proof
+// verification) use math/big, or the fixed-base table of the generator (see
+// FixedBaseTeeth).
+//
+// It is read by every exponentiation without synchronization, so it must be
+// set before any Protocol runs.
+var SecretExpBackend = ConstantTimeExp
 
 func sub(l, r *big.Int) *big.Int {
 	return new(big.Int).Sub(l, r)
@@ -43,6 +67,46 @@ func modInverse(g, x *big.Int) *big.Int {
 	return new(big.Int).ModInverse(g, x)
 }
 
-func modExp(g, x *big.Int) *big.Int {
-	return new(big.Int).Exp(g, x, P)
+func modExp(g, x, m *big.Int) *big.Int {
+	return new(big.Int).Exp(g, x, m)
+}
+
+// modExpSecret returns g^x mod m for a secret x, using the SecretExpBackend
+func modExpSecret(g, x *big.Int, mt *montgomery) *big.Int {
+	if SecretExpBackend == VariableTimeExp {
+		return modExp(g, x, mt.modulus)
+	}
+
+	return mt.exp(g, x)
+}
+
+// ctEq compares l and r in constant time. It leaks only the length of the
+// longest value.
+func ctEq(l, r *big.Int) bool {
+	size := len(l.Bytes())
+	if s := len(r.Bytes()); s > size {
+		size = s
+	}
+
+	lb := l.FillBytes(make([]byte, size))
+	rb := r.FillBytes(make([]byte, size))
+	return subtle.ConstantTimeCompare(lb, rb) == 1
+}
+
+// wipe overwrites every limb of the given values with zeros, including the
+// unused capacity. Copies made internally by math/big can not be reached.
+func wipe(xs ...*big.Int) {
+	for _, x := range xs {
+		if x == nil {
+			continue
+		}
+
+		limbs := x.Bits()
+		limbs = limbs[:cap(limbs)]
+		for i := range limbs {
+			limbs[i] = 0
+		}
+
+		x.SetInt64(0)
+	}
 }
@@ -0,0 +1,336 @@
+package smp
+
+import (
+	"errors"
+	"math/big"
+	"unicode/utf8"
+)
+
+// CBOR major types (RFC 8949, section 3.1)
+const (
+	cborUint  byte = 0 << 5
+	cborBytes byte = 2 << 5
+	cborText  byte = 3 << 5
+	cborArray byte = 4 << 5
+)
+
+var errQuestionNotUTF8 = errors.New("the question is not valid UTF-8")
+
+// marshalMessageCBOR encodes a message as the CBOR array
+//
+//	[version: uint, type: uint, ? question: tstr, mpis: [* bstr]]
+//
+// where the question is present only for SMP1Q, and the types are the ones
+// of the binary encoding. The encoding is deterministic (RFC 8949, section
+// 4.2.1): every length is definite and in its shortest form, and MPIs are
+// big-endian without leading zeros.
+func marshalMessageCBOR(t byte, question string, mpis []*big.Int) ([]byte, error) {
+	if err := checkMPIs(mpis); err != nil {
+		return nil, err
+	}
+
+	n := uint64(3)
+	if t == wireSMP1Q {
+		if !utf8.ValidString(question) {
+			return nil, errQuestionNotUTF8
+		}
+		n++
+	}
+
+	data := appendCBORHead(nil, cborArray, n)
+	data = appendCBORHead(data, cborUint, wireVersion)
+	data = appendCBORHead(data, cborUint, uint64(t))
+	if t == wireSMP1Q {
+		data = appendCBORHead(data, cborText, uint64(len(question)))
+		data = append(data, question...)
+	}
+
+	data = appendCBORHead(data, cborArray, uint64(len(mpis)))
+	for _, mpi := range mpis {
+		b := mpi.Bytes()
+		data = appendCBORHead(data, cborBytes, uint64(len(b)))
+		data = append(data, b...)
+	}
+
+	return data, nil
+}
+
+func appendCBORHead(l []byte, major byte, n uint64) []byte {
+	switch {
+	case n < 24:
+		return append(l, major|byte(n))
+	case n <= 0xff:
+		return append(l, major|24, byte(n))
+	case n <= 0xffff:
+		return append(l, major|25, byte(n>>8), byte(n))
+	case n <= 0xffffffff:
+		return append(l, major|26, byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+	}
+
+	return append(l, major|27,
+		byte(n>>56), byte(n>>48), byte(n>>40), byte(n>>32),
+		byte(n>>24), byte(n>>16), byte(n>>8), byte(n))
+}
+
+// cborReader decodes the deterministic CBOR of marshalMessageCBOR, and
+// rejects anything else: indefinite or longer than needed lengths, other
+// major types, tags and simple values.
+type cborReader struct {
+	d []byte
+}
+
+func (r *cborReader) head(major byte) (uint64, error) {
+	if len(r.d) == 0 {
+		return 0, malformed("truncated CBOR")
+	}
+
+	ib := r.d[0]
+	if ib&0xe0 != major {
+		return 0, malformed("unexpected CBOR major type")
+	}
+
+	ai := ib & 0x1f
+	r.d = r.d[1:]
+	if ai < 24 {
+		return uint64(ai), nil
+	}
+
+	if ai > 27 {
+		return 0, malformed("indefinite or reserved CBOR length")
+	}
+
+	size := 1 << (ai - 24)
+	if len(r.d) < size {
+		return 0, malformed("truncated CBOR")
+	}
+
+	var n uint64
+	for _, b := range r.d[:size] {
+		n = n<<8 | uint64(b)
+	}
+	r.d = r.d[size:]
+
+	// the shortest form needs all the bytes of the argument
+	if least := [4]uint64{24, 0xff + 1, 0xffff + 1, 0xffffffff + 1}[ai-24]; n < least {
+		return 0, malformed("non-canonical CBOR length")
+	}
+
+	return n, nil
+}
+
+func (r *cborReader) bytes(major byte) ([]byte, error) {
+	n, err := r.head(major)
+	if err != nil {
+		return nil, err
+	}
+
+	if n > uint64(len(r.d)) {
+		return nil, malformed("truncated CBOR")
+	}
+
+	b := r.d[:n]
+	r.d = r.d[n:]
+	return b, nil
+}
+
+func (r *cborReader) smallUint() (byte, error) {
+	n, err := r.head(cborUint)
+	if err != nil {
+		return 0, err
+	}
+
+	if n > 0xff {
+		return 0, malformed("CBOR integer out of range")
+	}
+
+	return byte(n), nil
+}
+
+func decodeMessageCBOR(data []byte) (byte, string, []*big.Int, error) {
+	r := &cborReader{data}
+
+	n, err := r.head(cborArray)
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	version, err := r.smallUint()
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	t, err := r.smallUint()
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	if err := checkMessageHeader(version, t); err != nil {
+		return 0, "", nil, err
+	}
+
+	expected := uint64(3)
+	if t == wireSMP1Q {
+		expected++
+	}
+
+	if n != expected {
+		return 0, "", nil, malformed("unexpected number of CBOR array items")
+	}
+
+	var question []byte
+	if t == wireSMP1Q {
+		if question, err = r.bytes(cborText); err != nil {
+			return 0, "", nil, err
+		}
+
+		if !utf8.Valid(question) {
+			return 0, "", nil, malformed("question is not valid UTF-8")
+		}
+	}
+
+	count, err := r.head(cborArray)
+	if err != nil {
+		return 0, "", nil, err
+	}
+
+	// every MPI takes at least one byte, which bounds the allocation
+	if count > uint64(len(r.d)) {
+		return 0, "", nil, malformed("truncated CBOR")
+	}
+
+	values := make([][]byte, count)
+	for i := range values {
+		if values[i], err = r.bytes(cborBytes); err != nil {
+			return 0, "", nil, err
+		}
+	}
+
+	if len(r.d) != 0 {
+		return 0, "", nil, malformed("trailing data")
+	}
+
+	mpis, err := messageMPIs(t, values)
+	return t, string(question), mpis, err
+}
+
+// unmarshalMessageCBOR decodes a CBOR message and checks it is of the given
+// type
+func unmarshalMessageCBOR(data []byte, want byte) (Message, error) {
+	t, question, mpis, err := decodeMessageCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	if t != want {
+		return nil, malformed("unexpected message type " + jsonTypes[t])
+	}
+
+	return newMessage(t, question, mpis)
+}
+
+// UnmarshalMessageCBOR decodes a message encoded by its MarshalCBOR method
+func UnmarshalMessageCBOR(data []byte) (Message, error) {
+	t, question, mpis, err := decodeMessageCBOR(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMessage(t, question, mpis)
+}
+
+// MarshalCBOR encodes the message in the deterministic CBOR read by
+// UnmarshalMessageCBOR
+func (m SMP1) MarshalCBOR() ([]byte, error) {
+	return marshalMessageCBOR(wireSMP1, "", m.MPIs())
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR
+func (m *SMP1) UnmarshalCBOR(data []byte) error {
+	v, err := unmarshalMessageCBOR(data, wireSMP1)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP1)
+	return nil
+}
+
+// MarshalCBOR encodes the message in the deterministic CBOR read by
+// UnmarshalMessageCBOR. The question must be valid UTF-8.
+func (m SMP1Q) MarshalCBOR() ([]byte, error) {
+	return marshalMessageCBOR(wireSMP1Q, m.question, m.MPIs())
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR
+func (m *SMP1Q) UnmarshalCBOR(data []byte) error {
+	v, err := unmarshalMessageCBOR(data, wireSMP1Q)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP1Q)
+	return nil
+}
+
+// MarshalCBOR encodes the message in the deterministic CBOR read by
+// UnmarshalMessageCBOR
+func (m SMP2) MarshalCBOR() ([]byte, error) {
+	return marshalMessageCBOR(wireSMP2, "", m.MPIs())
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR
+func (m *SMP2) UnmarshalCBOR(data []byte) error {
+	v, err := unmarshalMessageCBOR(data, wireSMP2)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP2)
+	return nil
+}
+
+// MarshalCBOR encodes the message in the deterministic CBOR read by
+// UnmarshalMessageCBOR
+func (m SMP3) MarshalCBOR() ([]byte, error) {
+	return marshalMessageCBOR(wireSMP3, "", m.MPIs())
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR
+func (m *SMP3) UnmarshalCBOR(data []byte) error {
+	v, err := unmarshalMessageCBOR(data, wireSMP3)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP3)
+	return nil
+}
+
+// MarshalCBOR encodes the message in the deterministic CBOR read by
+// UnmarshalMessageCBOR
+func (m SMP4) MarshalCBOR() ([]byte, error) {
+	return marshalMessageCBOR(wireSMP4, "", m.MPIs())
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR
+func (m *SMP4) UnmarshalCBOR(data []byte) error {
+	v, err := unmarshalMessageCBOR(data, wireSMP4)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP4)
+	return nil
+}
+
+// MarshalCBOR encodes the message in the deterministic CBOR read by
+// UnmarshalMessageCBOR
+func (m SMPAbort) MarshalCBOR() ([]byte, error) {
+	return marshalMessageCBOR(wireSMPAbort, "", nil)
+}
+
+// UnmarshalCBOR decodes a message encoded by MarshalCBOR
+func (m *SMPAbort) UnmarshalCBOR(data []byte) error {
+	_, err := unmarshalMessageCBOR(data, wireSMPAbort)
+	return err
+}
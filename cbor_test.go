@@ -0,0 +1,129 @@
+package smp
+
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestMessagesRoundTripThroughCBOR(t *testing.T) {
+	msgs := seededRun("alice cbor seed", "bob cbor seed")
+	q, _ := NewSMP1Q("¿cuál es la respuesta?", msgs[0].MPIs()...)
+	msgs = append(msgs, *q, SMPAbort{})
+
+	type cborMessage interface {
+		MarshalCBOR() ([]byte, error)
+	}
+
+	type cborDest interface {
+		UnmarshalCBOR([]byte) error
+	}
+
+	for _, m := range msgs {
+		data, err := m.(cborMessage).MarshalCBOR()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := UnmarshalMessageCBOR(data)
+		if err != nil {
+			t.Fatalf("%s: %v", messageType(m), err)
+		}
+
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s: decoded %#v", messageType(m), got)
+		}
+
+		// the concrete type decodes its own encoding
+		dest := reflect.New(reflect.TypeOf(m))
+		if err := dest.Interface().(cborDest).UnmarshalCBOR(data); err != nil {
+			t.Errorf("%s: %v", messageType(m), err)
+		}
+
+		if !reflect.DeepEqual(dest.Elem().Interface(), m) {
+			t.Errorf("%s: UnmarshalCBOR decoded %#v", messageType(m), dest.Interface())
+		}
+	}
+}
+
+func TestCBORFormat(t *testing.T) {
+	abort, _ := SMPAbort{}.MarshalCBOR()
+	if !bytes.Equal(abort, []byte{0x83, 0x01, 0x06, 0x80}) {
+		t.Errorf("unexpected SMPAbort encoding %x", abort)
+	}
+
+	m, _ := NewSMP4(new(big.Int).SetBytes(bytes.Repeat([]byte{0xff}, 24)), big.NewInt(0), big.NewInt(1))
+	data, _ := m.MarshalCBOR()
+	expected := append(append([]byte{0x83, 0x01, 0x05, 0x83, 0x58, 24}, bytes.Repeat([]byte{0xff}, 24)...), 0x40, 0x41, 0x01)
+	if !bytes.Equal(data, expected) {
+		t.Errorf("unexpected SMP4 encoding %x", data)
+	}
+
+	q, _ := NewSMP1Q("?", big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1))
+	data, _ = q.MarshalCBOR()
+	if !bytes.HasPrefix(data, []byte{0x84, 0x01, 0x07, 0x61, '?', 0x86}) {
+		t.Errorf("unexpected SMP1Q encoding %x", data)
+	}
+
+	q, _ = NewSMP1Q("\xff", big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1))
+	if _, err := q.MarshalCBOR(); err != errQuestionNotUTF8 {
+		t.Errorf("expected %v, got %v", errQuestionNotUTF8, err)
+	}
+}
+
+func TestMalformedCBORMessagesAreRejected(t *testing.T) {
+	smp4 := []byte{0x83, 0x01, 0x05, 0x83, 0x41, 0x01, 0x41, 0x02, 0x41, 0x03}
+	if _, err := UnmarshalMessageCBOR(smp4); err != nil {
+		t.Fatalf("valid message rejected: %v", err)
+	}
+
+	cases := map[string][]byte{
+		"empty":            {},
+		"not an array":     {0xa0},
+		"version":          {0x83, 0x02, 0x05, 0x83, 0x41, 0x01, 0x41, 0x02, 0x41, 0x03},
+		"unknown type":     {0x83, 0x01, 0x09, 0x80},
+		"long head":        {0x98, 0x03, 0x01, 0x05, 0x83, 0x41, 0x01, 0x41, 0x02, 0x41, 0x03},
+		"long length":      {0x83, 0x01, 0x05, 0x83, 0x58, 0x01, 0x01, 0x41, 0x02, 0x41, 0x03},
+		"indefinite array": {0x83, 0x01, 0x05, 0x9f, 0x41, 0x01, 0x41, 0x02, 0x41, 0x03, 0xff},
+		"indefinite bytes": {0x83, 0x01, 0x05, 0x83, 0x5f, 0x41, 0x01, 0xff, 0x41, 0x02, 0x41, 0x03},
+		"bignum tag":       {0x83, 0x01, 0x05, 0x83, 0xc2, 0x41, 0x01, 0x41, 0x02, 0x41, 0x03},
+		"text MPI":         {0x83, 0x01, 0x05, 0x83, 0x61, 0x01, 0x41, 0x02, 0x41, 0x03},
+		"count":            {0x83, 0x01, 0x05, 0x82, 0x41, 0x01, 0x41, 0x02},
+		"items":            {0x84, 0x01, 0x05, 0x83, 0x41, 0x01, 0x41, 0x02, 0x41, 0x03},
+		"leading zero":     {0x83, 0x01, 0x05, 0x83, 0x42, 0x00, 0x01, 0x41, 0x02, 0x41, 0x03},
+		"truncated":        smp4[:len(smp4)-1],
+		"trailing data":    append(append([]byte{}, smp4...), 0x00),
+		"huge count":       {0x83, 0x01, 0x05, 0x9b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"huge length":      {0x83, 0x01, 0x05, 0x83, 0x5b, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff, 0xff},
+		"no question":      {0x83, 0x01, 0x07, 0x80},
+		"invalid UTF-8":    {0x84, 0x01, 0x07, 0x61, 0xff, 0x86, 0x41, 0x01, 0x41, 0x01, 0x41, 0x01, 0x41, 0x01, 0x41, 0x01, 0x41, 0x01},
+	}
+
+	for name, data := range cases {
+		_, err := UnmarshalMessageCBOR(data)
+
+		var me *MalformedMessageError
+		if !errors.As(err, &me) {
+			t.Errorf("%s: expected a MalformedMessageError, got %v", name, err)
+		}
+	}
+
+	var m SMP3
+	if err := m.UnmarshalCBOR(smp4); err == nil {
+		t.Errorf("SMP3 should not decode an SMP4")
+	}
+}
+
+func TestZeroValueMessagesAreNotEncodedInCBOR(t *testing.T) {
+	type cborMarshaler interface {
+		MarshalCBOR() ([]byte, error)
+	}
+
+	for _, m := range []cborMarshaler{SMP1{}, SMP1Q{}, SMP2{}, SMP3{}, SMP4{}} {
+		if _, err := m.MarshalCBOR(); err != errMissingMPI {
+			t.Errorf("%T: expected %v, got %v", m, errMissingMPI, err)
+		}
+	}
+}
@@ -1,8 +1,14 @@
 package channel
 
-import "github.com/juniorz/smp"
+import (
+	"math/big"
 
-// Protocol represents a channel-based SMP protocol
+	"github.com/juniorz/smp"
+)
+
+// Protocol represents a channel-based SMP protocol.
+// Compare and Respond can be called while the receive loop runs, since the
+// underlying smp.Protocol is safe for concurrent use.
 type Protocol struct {
 	*smp.Protocol
 
@@ -12,9 +18,14 @@ type Protocol struct {
 
 // NewProtocol returns a channel-based SMP protocol
 func NewProtocol(version int) *Protocol {
-	return &Protocol{
-		Protocol: smp.NewProtocol(version),
+	p := &Protocol{
+		Protocol: smp.NewProtocol(smp.DefaultOptions()),
 	}
+
+	// opt in to the events channel before any event is emitted
+	p.Events()
+
+	return p
 }
 
 // Receive returns the peer's receive channel.
@@ -56,6 +67,19 @@ func (p *Protocol) Compare() <-chan smp.Event {
 	return p.Events()
 }
 
+// Respond to the protocol started by the other peer with this peer's secret,
+// after it asked for one.
+func (p *Protocol) Respond(secret *big.Int) error {
+	m, err := p.Protocol.Respond(secret)
+	if err != nil {
+		return err
+	}
+
+	p.Send() <- m
+
+	return nil
+}
+
 func (p *Protocol) receiveLoop() {
 	for m := range p.receiveC {
 		send, _ := p.Protocol.Receive(m)
@@ -47,13 +47,13 @@ func TestComparesIdenticalSecrets(t *testing.T) {
 	bob.Pipe(alice)
 
 	select {
-	case in := <-alice.Compare():
+	case in := <-outcome(alice.Compare()):
 		switch in {
 		case smp.Success:
 		default:
-			t.Errorf("SMP protocol failed", in)
+			t.Errorf("SMP protocol failed: %v", in)
 		}
-	case <-time.After(1 * time.Second):
+	case <-time.After(timeout):
 		t.Errorf("SMP protocol failed")
 	}
 
@@ -71,15 +71,87 @@ func TestFailsToCompareDifferentSecrets(t *testing.T) {
 	bob.Pipe(alice)
 
 	select {
-	case in := <-alice.Compare():
+	case in := <-outcome(alice.Compare()):
 		switch in {
 		default:
 			//so many error cases
 		case smp.Success:
-			t.Errorf("SMP protocol succeeded", in)
+			t.Errorf("SMP protocol succeeded: %v", in)
 		}
-	case <-time.After(1 * time.Second):
+	case <-time.After(timeout):
 		t.Errorf("SMP protocol did not fail")
 	}
 
 }
+
+// outcome skips the progress events and returns the outcome of the protocol
+func outcome(events <-chan smp.Event) <-chan smp.Event {
+	ret := make(chan smp.Event, 1)
+	go func() {
+		for e := range events {
+			if e != smp.InProgress {
+				ret <- e
+				return
+			}
+		}
+	}()
+
+	return ret
+}
+
+func TestRespondsAfterBeingAskedForTheSecret(t *testing.T) {
+	alice := NewProtocol(3)
+	bob := NewProtocol(3)
+
+	alice.Secret = big.NewInt(123456)
+	alice.Pipe(bob)
+	bob.Pipe(alice)
+
+	results := outcome(alice.Compare())
+
+	select {
+	case e := <-bob.Events():
+		if e != smp.InProgress {
+			t.Fatalf("expected to be asked for the secret, got %v", e)
+		}
+	case <-time.After(timeout):
+		t.Fatalf("bob was not asked for the secret")
+	}
+
+	if err := bob.Respond(big.NewInt(123456)); err != nil {
+		t.Fatal(err)
+	}
+
+	select {
+	case in := <-results:
+		if in != smp.Success {
+			t.Errorf("SMP protocol failed: %v", in)
+		}
+	case <-time.After(timeout):
+		t.Errorf("SMP protocol failed")
+	}
+}
+
+func TestConcurrentComparesAreRaceFree(t *testing.T) {
+	alice := NewProtocol(3)
+	bob := NewProtocol(3)
+
+	alice.Secret = big.NewInt(123456)
+	bob.Secret = big.NewInt(123456)
+	alice.Pipe(bob)
+	bob.Pipe(alice)
+
+	// both peers start at the same time, so the receive loops run
+	// concurrently with Compare and the crossing SMP1s abort the protocol
+	results := make(chan smp.Event, 2)
+	go func() { results <- <-outcome(alice.Compare()) }()
+	go func() { results <- <-outcome(bob.Compare()) }()
+
+	for i := 0; i < 2; i++ {
+		select {
+		case <-results:
+		case <-time.After(timeout):
+			t.Fatalf("the protocol did not finish")
+		}
+	}
+}
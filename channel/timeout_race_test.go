@@ -0,0 +1,10 @@
+//go:build race
+
+package channel
+
+import "time"
+
+// timeout is how long the tests wait for a whole exchange. The race detector
+// instruments every word operation of the 1536-bit arithmetic, which makes an
+// exchange over ten times slower.
+const timeout = 10 * time.Second
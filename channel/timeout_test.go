@@ -0,0 +1,8 @@
+//go:build !race
+
+package channel
+
+import "time"
+
+// timeout is how long the tests wait for a whole exchange
+const timeout = 1 * time.Second
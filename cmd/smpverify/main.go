@@ -0,0 +1,93 @@
+// Command smpverify checks the zero knowledge proofs of an SMP run, exported
+// as JSON by smp.Transcript, without either secret.
+//
+// Usage:
+//
+//	smpverify [-group modp1536|ed448|ristretto255] [transcript.json]
+//
+// The transcript is read from the standard input if no file is given. Only
+// the messages up to the first SMPAbort are checked. It exits with status 1
+// if the run is malformed or a proof is invalid.
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io"
+	"os"
+
+	"github.com/juniorz/smp"
+	"github.com/juniorz/smp/otrv4"
+	"github.com/juniorz/smp/ristretto"
+)
+
+func main() {
+	group := flag.String("group", "modp1536", "group of the run: modp1536, ed448 or ristretto255")
+	flag.Parse()
+
+	var options smp.Options
+	switch *group {
+	case "modp1536":
+		options = smp.DefaultOptions()
+	case "ed448":
+		options = otrv4.Options()
+	case "ristretto255":
+		options = ristretto.Options{}
+	default:
+		fatal(fmt.Errorf("unknown group %q", *group))
+	}
+
+	in := io.Reader(os.Stdin)
+	if flag.NArg() > 0 {
+		f, err := os.Open(flag.Arg(0))
+		if err != nil {
+			fatal(err)
+		}
+		defer f.Close()
+		in = f
+	}
+
+	transcript := &smp.Transcript{}
+	if err := json.NewDecoder(in).Decode(transcript); err != nil {
+		fatal(err)
+	}
+
+	var msgs []smp.Message
+	for _, e := range transcript.Entries() {
+		if e.Type == "SMPAbort" {
+			fmt.Printf("run aborted after %d messages\n", len(msgs))
+			break
+		}
+
+		m, err := e.Message()
+		if err != nil {
+			fatal(err)
+		}
+
+		msgs = append(msgs, m)
+	}
+
+	report := smp.VerifyTranscript(options, msgs)
+	fmt.Printf("well formed: %t\n", report.WellFormed)
+	for _, p := range report.Problems {
+		fmt.Printf("problem: %s\n", p)
+	}
+
+	for _, c := range report.Checks {
+		if c.Reason != "" {
+			fmt.Printf("%s %s: %s (%s)\n", c.Message, c.Proof, c.Status, c.Reason)
+		} else {
+			fmt.Printf("%s %s: %s\n", c.Message, c.Proof, c.Status)
+		}
+	}
+
+	if !report.Valid() {
+		os.Exit(1)
+	}
+}
+
+func fatal(err error) {
+	fmt.Fprintln(os.Stderr, "smpverify:", err)
+	os.Exit(2)
+}
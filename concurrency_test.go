@@ -0,0 +1,105 @@
+package smp
+
+import (
+	"math/big"
+	"sync"
+	"testing"
+)
+
+// pump delivers the messages from in to p, and its replies to out
+func pump(p *Protocol, in <-chan Message, out chan<- Message, done <-chan struct{}) {
+	for {
+		select {
+		case <-done:
+			return
+		case m := <-in:
+			r, _ := p.Receive(m)
+			if r == nil {
+				continue
+			}
+
+			select {
+			case out <- r:
+			case <-done:
+				return
+			}
+		}
+	}
+}
+
+func TestConcurrentUseIsRaceFree(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	alice.Question = "where?"
+	bob := NewProtocol(DefaultOptions())
+
+	aliceEvents, bobEvents := alice.Events(), bob.Events()
+
+	toAlice := make(chan Message, 8)
+	toBob := make(chan Message, 8)
+	done := make(chan struct{})
+
+	var pumps sync.WaitGroup
+	pumps.Add(2)
+	go func() { defer pumps.Done(); pump(alice, toAlice, toBob, done) }()
+	go func() { defer pumps.Done(); pump(bob, toBob, toAlice, done) }()
+
+	send := func(c chan<- Message, msgs ...Message) {
+		for _, m := range msgs {
+			if m == nil {
+				continue
+			}
+
+			select {
+			case c <- m:
+			case <-done:
+			}
+		}
+	}
+
+	var workers sync.WaitGroup
+	ops := []func(){
+		func() {
+			m, _ := alice.Compare()
+			send(toBob, m)
+		},
+		func() {
+			msgs, _ := alice.Restart()
+			send(toBob, msgs...)
+		},
+		func() {
+			m, _ := bob.Respond(big.NewInt(42))
+			send(toAlice, m)
+		},
+		func() { send(toAlice, bob.Abort()) },
+		func() { bob.PendingQuestion() },
+		func() { alice.MarshalBinary() },
+		func() {
+			for len(aliceEvents) > 0 {
+				<-aliceEvents
+			}
+		},
+		func() {
+			for len(bobEvents) > 0 {
+				<-bobEvents
+			}
+		},
+	}
+
+	for i := 0; i < 4; i++ {
+		workers.Add(1)
+		go func(i int) {
+			defer workers.Done()
+			for j := 0; j < 2*len(ops); j++ {
+				ops[(i+j)%len(ops)]()
+			}
+		}(i)
+	}
+
+	workers.Wait()
+	close(done)
+	pumps.Wait()
+
+	alice.Close()
+	bob.Close()
+}
@@ -18,20 +18,50 @@ func appendMPI(l []byte, r *big.Int) []byte {
 	return appendData(l, r.Bytes())
 }
 
-func hashMPIsBN(h hash.Hash, magic byte, mpis ...*big.Int) *big.Int {
-	return new(big.Int).SetBytes(hashMPIs(h, magic, mpis...))
+func hashMPIsBN(h hash.Hash, prefix []byte, magic byte, mpis ...*big.Int) *big.Int {
+	return new(big.Int).SetBytes(hashMPIs(h, prefix, magic, mpis...))
 }
 
-func hashMPIs(h hash.Hash, magic byte, mpis ...*big.Int) []byte {
+func hashMPIs(h hash.Hash, prefix []byte, magic byte, mpis ...*big.Int) []byte {
 	if h != nil {
 		h.Reset()
 	} else {
 		h = sha256.New()
 	}
 
+	h.Write(prefix)
 	h.Write([]byte{magic})
 	for _, mpi := range mpis {
 		h.Write(appendMPI(nil, mpi))
 	}
 	return h.Sum(nil)
 }
+
+func extractWord(d []byte) ([]byte, uint32, bool) {
+	if len(d) < 4 {
+		return nil, 0, false
+	}
+
+	return d[4:], uint32(d[0])<<24 |
+		uint32(d[1])<<16 |
+		uint32(d[2])<<8 |
+		uint32(d[3]), true
+}
+
+func extractData(d []byte) ([]byte, []byte, bool) {
+	d, l, ok := extractWord(d)
+	if !ok || uint32(len(d)) < l {
+		return nil, nil, false
+	}
+
+	return d[l:], d[:l], true
+}
+
+func extractMPI(d []byte) ([]byte, *big.Int, bool) {
+	d, b, ok := extractData(d)
+	if !ok {
+		return nil, nil, false
+	}
+
+	return d, new(big.Int).SetBytes(b), true
+}
@@ -0,0 +1,78 @@
+package smp
+
+import (
+	"context"
+	"time"
+)
+
+// awaitsPeer returns whether the protocol is waiting for a reply of the peer
+func awaitsPeer(s smpState) bool {
+	switch s.(type) {
+	case smpStateExpect2, smpStateExpect3, smpStateExpect4:
+		return true
+	}
+
+	return false
+}
+
+// awaitReply starts the deadline for the reply of the peer, if the protocol
+// waits for one. The deadline is ctx or StepTimeout, whichever expires first.
+// It must be called with p.mu held.
+func (p *Protocol) awaitReply(ctx context.Context) {
+	if !awaitsPeer(p.smpState) {
+		return
+	}
+
+	if ctx.Done() == nil && p.StepTimeout <= 0 {
+		return
+	}
+
+	var timer *time.Timer
+	var timeout <-chan time.Time
+	if p.StepTimeout > 0 {
+		timer = time.NewTimer(p.StepTimeout)
+		timeout = timer.C
+	}
+
+	stop := make(chan struct{})
+	p.stopWait = stop
+
+	go func() {
+		select {
+		case <-stop:
+		case <-ctx.Done():
+			p.expire(stop, ctx.Err())
+		case <-timeout:
+			p.expire(stop, context.DeadlineExceeded)
+		}
+
+		if timer != nil {
+			timer.Stop()
+		}
+	}()
+}
+
+// stopWaiting cancels the deadline of the current step.
+// It must be called with p.mu held.
+func (p *Protocol) stopWaiting() {
+	if p.stopWait != nil {
+		close(p.stopWait)
+		p.stopWait = nil
+	}
+}
+
+// expire aborts the protocol, unless the step whose deadline is stop is over
+func (p *Protocol) expire(stop chan struct{}, err error) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	if p.stopWait != stop {
+		return
+	}
+	p.stopWait = nil
+
+	before := p.smpState
+	p.smpState, _, _ = abortState(p, err)
+	p.record(Sent, before, SMPAbort{})
+	p.event(TimedOut{State: stateName(before), Err: err, Message: SMPAbort{}})
+}
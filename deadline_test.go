@@ -0,0 +1,87 @@
+package smp
+
+import (
+	"context"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func timedOut(p *Protocol) <-chan TimedOut {
+	ret := make(chan TimedOut, 1)
+	p.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		if t, ok := e.(TimedOut); ok {
+			ret <- t
+		}
+	})
+
+	return ret
+}
+
+func TestStepTimeoutAbortsTheProtocol(t *testing.T) {
+	p := NewProtocol(DefaultOptions())
+	p.Secret = big.NewInt(42)
+	p.StepTimeout = 10 * time.Millisecond
+	events := timedOut(p)
+
+	p.Compare()
+
+	select {
+	case e := <-events:
+		expected := TimedOut{State: "SMPSTATE_EXPECT2", Err: context.DeadlineExceeded, Message: SMPAbort{}}
+		if e != expected {
+			t.Errorf("expected %v, got %v", expected, e)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("the protocol did not time out")
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if _, ok := p.smpState.(smpStateExpect1); !ok || p.s1 != nil {
+		t.Errorf("expected the protocol to be aborted and wiped")
+	}
+}
+
+func TestContextAbortsTheProtocol(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+	events := timedOut(bob)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	m, _ := alice.Compare()
+	bob.ReceiveContext(ctx, m)
+	cancel()
+
+	select {
+	case e := <-events:
+		if e.State != "SMPSTATE_EXPECT3" || e.Err != context.Canceled {
+			t.Errorf("unexpected event %v", e)
+		}
+	case <-time.After(1 * time.Second):
+		t.Fatalf("the protocol did not abort")
+	}
+}
+
+func TestRepliesInTimeStopTheDeadline(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	alice.StepTimeout = 10 * time.Second
+
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+
+	m, _ := alice.Compare()
+	m, _ = bob.Receive(m)
+	m, _ = alice.Receive(m)
+	m, _ = bob.Receive(m)
+	if _, err := alice.Receive(m); err != nil {
+		t.Fatal(err)
+	}
+
+	if alice.stopWait != nil {
+		t.Errorf("expected no deadline after the protocol ends")
+	}
+}
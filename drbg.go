@@ -0,0 +1,85 @@
+package smp
+
+import (
+	"crypto/hmac"
+	"crypto/sha256"
+)
+
+// maxDRBGRequest is the maximum number of bytes generated per request, as
+// defined by NIST SP 800-90A for HMAC_DRBG
+const maxDRBGRequest = 1 << 16
+
+// HMACDRBG is the HMAC_DRBG of NIST SP 800-90A with SHA-256, without
+// prediction resistance nor reseeding. Its output is fully determined by the
+// seed: use it as the Rand of a Protocol to make a run reproducible in tests
+// and test vectors. It is not safe for concurrent use.
+type HMACDRBG struct {
+	k, v []byte
+}
+
+// NewHMACDRBG returns an HMACDRBG instantiated with seed (the entropy input
+// and nonce) and the optional personalization string
+func NewHMACDRBG(seed, personalization []byte) *HMACDRBG {
+	d := &HMACDRBG{
+		k: make([]byte, sha256.Size),
+		v: make([]byte, sha256.Size),
+	}
+
+	for i := range d.v {
+		d.v[i] = 0x01
+	}
+
+	d.update(seed, personalization)
+	return d
+}
+
+func (d *HMACDRBG) hmac(data ...[]byte) []byte {
+	h := hmac.New(sha256.New, d.k)
+	for _, b := range data {
+		h.Write(b)
+	}
+
+	return h.Sum(nil)
+}
+
+func (d *HMACDRBG) update(data ...[]byte) {
+	d.k = d.hmac(append([][]byte{d.v, {0x00}}, data...)...)
+	d.v = d.hmac(d.v)
+
+	provided := 0
+	for _, b := range data {
+		provided += len(b)
+	}
+
+	if provided == 0 {
+		return
+	}
+
+	d.k = d.hmac(append([][]byte{d.v, {0x01}}, data...)...)
+	d.v = d.hmac(d.v)
+}
+
+// Read fills b with pseudorandom bytes. It never fails.
+func (d *HMACDRBG) Read(b []byte) (int, error) {
+	n := len(b)
+	for len(b) > 0 {
+		l := len(b)
+		if l > maxDRBGRequest {
+			l = maxDRBGRequest
+		}
+
+		d.generate(b[:l])
+		b = b[l:]
+	}
+
+	return n, nil
+}
+
+func (d *HMACDRBG) generate(b []byte) {
+	for i := 0; i < len(b); {
+		d.v = d.hmac(d.v)
+		i += copy(b[i:], d.v)
+	}
+
+	d.update()
+}
@@ -0,0 +1,85 @@
+package smp
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestHMACDRBGKnownAnswer(t *testing.T) {
+	// NIST CAVP HMAC_DRBG, SHA-256, no prediction resistance, no reseed, COUNT = 0
+	entropy, _ := hex.DecodeString("ca851911349384bffe89de1cbdc46e6831e44d34a4fb935ee285dd14b71a7488")
+	nonce, _ := hex.DecodeString("659ba96c601dc69fc902940805ec0ca8")
+	expected, _ := hex.DecodeString("e528e9abf2dece54d47c7e75e5fe302149f817ea9fb4bee6f4199697d04d5b89" +
+		"d54fbb978a15b5c443c9ec21036d2460b6f73ebad0dc2aba6e624abf07745bc1" +
+		"07694bb7547bb0995f70de25d6b29e2d3011bb19d27676c07162c8b5ccde0668" +
+		"961df86803482cb37ed6d5c0bb8d50cf1f50d476aa0458bdaba806f48be9dcb8")
+
+	d := NewHMACDRBG(append(entropy, nonce...), nil)
+	out := make([]byte, len(expected))
+	d.Read(out)
+	d.Read(out)
+
+	if !bytes.Equal(out, expected) {
+		t.Errorf("expected %x, got %x", expected, out)
+	}
+}
+
+// seededRun compares the secrets with protocols seeded from the given seeds, and
+// returns every message exchanged
+func seededRun(aliceSeed, bobSeed string) []Message {
+	alice := NewProtocol(DefaultOptions())
+	alice.Rand = NewHMACDRBG([]byte(aliceSeed), nil)
+	alice.Secret = big.NewInt(42)
+
+	bob := NewProtocol(DefaultOptions())
+	bob.Rand = NewHMACDRBG([]byte(bobSeed), nil)
+	bob.Secret = big.NewInt(42)
+
+	m1, _ := alice.Compare()
+	m2, _ := bob.Receive(m1)
+	m3, _ := alice.Receive(m2)
+	m4, _ := bob.Receive(m3)
+
+	return []Message{m1, m2, m3, m4}
+}
+
+func TestSeededRunsAreReproducible(t *testing.T) {
+	first := seededRun("alice test seed", "bob test seed")
+	if _, ok := first[3].(SMP4); !ok {
+		t.Fatalf("expected SMP4, got %T", first[3])
+	}
+
+	if !reflect.DeepEqual(first, seededRun("alice test seed", "bob test seed")) {
+		t.Errorf("runs with the same seeds differ")
+	}
+
+	if reflect.DeepEqual(first, seededRun("alice test seed", "another seed")) {
+		t.Errorf("runs with different seeds should differ")
+	}
+}
+
+func TestExponentsAreSampledInRange(t *testing.T) {
+	q := MODP1536.Order()
+	size := (q.BitLen() + 7) / 8
+
+	// q+2^1535 is masked to q, then 0 and 1: only 1 is in range
+	var rand []byte
+	rand = append(rand, new(big.Int).Add(q, new(big.Int).Lsh(big.NewInt(1), uint(q.BitLen()))).Bytes()...)
+	rand = append(rand, make([]byte, size)...)
+	rand = append(rand, big.NewInt(1).FillBytes(make([]byte, size))...)
+
+	p := NewProtocol(DefaultOptions())
+	p.Rand = bytes.NewReader(rand)
+
+	x, err := p.randExponent()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !eq(x, big.NewInt(1)) {
+		t.Errorf("expected q and 0 to be rejected, got %x", x)
+	}
+}
@@ -0,0 +1,64 @@
+package smp
+
+import "errors"
+
+const (
+	reasonInvalidGroupElement = "is an invalid group element"
+	reasonInvalidProof        = "is not a valid zero knowledge proof"
+)
+
+var (
+	// ErrSecretMismatch means the protocol completed and the secrets do not match
+	ErrSecretMismatch = errors.New("protocol failed: x != y")
+
+	// ErrRandomnessFailure means the Rand source of the protocol could not
+	// provide enough random bytes
+	ErrRandomnessFailure = errors.New("short read from rand source")
+)
+
+// InvalidGroupElementError means a value received from the peer is not a
+// valid group element
+type InvalidGroupElementError struct {
+	// Message is the message that carried the value, like "SMP2"
+	Message string
+	// Field is the name of the value, as in the spec, like "g2b"
+	Field string
+}
+
+func (e *InvalidGroupElementError) Error() string {
+	return e.Message + ": " + e.Field + " " + reasonInvalidGroupElement
+}
+
+// InvalidProofError means a zero knowledge proof received from the peer did
+// not verify
+type InvalidProofError struct {
+	// Message is the message that carried the proof, like "SMP3"
+	Message string
+	// Proof is the name of the proof, as in the spec, like "cP"
+	Proof string
+}
+
+func (e *InvalidProofError) Error() string {
+	return e.Message + ": " + e.Proof + " " + reasonInvalidProof
+}
+
+// UnexpectedMessageError means the peer sent a message that is not valid in
+// the current state
+type UnexpectedMessageError struct {
+	// State is the state of the protocol, as in the spec, like "SMPSTATE_EXPECT1"
+	State string
+	// Got is the message received, like "SMP3"
+	Got string
+}
+
+func (e *UnexpectedMessageError) Error() string {
+	return "unexpected " + e.Got + " in " + e.State
+}
+
+func invalidGroupElement(message, field string) error {
+	return &InvalidGroupElementError{Message: message, Field: field}
+}
+
+func invalidProof(message, proof string) error {
+	return &InvalidProofError{Message: message, Proof: proof}
+}
@@ -0,0 +1,209 @@
+package smp
+
+// eventBufferSize is the capacity of the events channel
+const eventBufferSize = 16
+
+// EventDetail is an event with the details of what happened.
+// Kind returns the coarse Event it corresponds to.
+type EventDetail interface {
+	Kind() Event
+}
+
+// AskForSecret means the peer started the protocol and our secret is needed
+// to continue. Question is empty if the peer did not ask a question.
+type AskForSecret struct {
+	Question string
+}
+
+// Progress means the protocol advanced to the given step (1 to 3) and is
+// Percent complete
+type Progress struct {
+	Percent int
+	Step    int
+}
+
+// Succeeded means the protocol completed and the secrets match
+type Succeeded struct{}
+
+// Failed means the protocol completed and the secrets do not match, or it could
+// not continue because of a local error. Err tells which: ErrSecretMismatch,
+// ErrRandomnessFailure or another local error.
+type Failed struct {
+	Err error
+}
+
+// CheatDetected means a value received from the peer did not verify.
+// Field is the name of the value, as in the spec, and Reason why it failed.
+// Err is an *InvalidGroupElementError or an *InvalidProofError.
+type CheatDetected struct {
+	Field  string
+	Reason string
+	Err    error
+}
+
+// Aborted means the protocol was aborted, either by us or by the peer
+type Aborted struct {
+	ByPeer bool
+}
+
+// TimedOut means the peer did not reply in time and the protocol aborted
+// itself while in State. Err is context.DeadlineExceeded for StepTimeout, or
+// the error of the context that expired. Message is the SMPAbort that must be
+// sent to the peer.
+type TimedOut struct {
+	State   string
+	Err     error
+	Message Message
+}
+
+// Restarted means the protocol was restarted while in progress, either by us
+// with Restart or by the peer, who sent an SMP1 right after aborting
+type Restarted struct {
+	ByPeer bool
+}
+
+// ProtocolError means the peer sent a message we did not expect.
+// Err is an *UnexpectedMessageError.
+type ProtocolError struct {
+	Reason string
+	Err    error
+}
+
+// Kind returns InProgress
+func (AskForSecret) Kind() Event { return InProgress }
+
+// Kind returns InProgress
+func (Progress) Kind() Event { return InProgress }
+
+// Kind returns Success
+func (Succeeded) Kind() Event { return Success }
+
+// Kind returns Failure
+func (Failed) Kind() Event { return Failure }
+
+// Kind returns Cheated
+func (CheatDetected) Kind() Event { return Cheated }
+
+// Kind returns Abort
+func (Aborted) Kind() Event { return Abort }
+
+// Kind returns Abort
+func (TimedOut) Kind() Event { return Abort }
+
+// Kind returns InProgress
+func (Restarted) Kind() Event { return InProgress }
+
+// Kind returns Error
+func (ProtocolError) Kind() Event { return Error }
+
+// EventHandler handles the events emitted by a Protocol.
+// HandleEvent is called in order, once the Protocol is unlocked, by the
+// goroutine that drives the Protocol (the one calling Compare, Receive, Abort
+// or Restart, or the one that expires a step) before that call returns. It may
+// call methods of the Protocol, like Respond after an AskForSecret: the events
+// of a nested call are delivered after the current one. While a goroutine
+// delivers events, the events of calls made by other goroutines are delivered
+// by it, so those calls can return first.
+type EventHandler interface {
+	HandleEvent(EventDetail)
+}
+
+// EventHandlerFunc adapts an ordinary function to an EventHandler
+type EventHandlerFunc func(EventDetail)
+
+// HandleEvent calls f(e)
+func (f EventHandlerFunc) HandleEvent(e EventDetail) {
+	f(e)
+}
+
+// Events opts in to receive the kind of the events of this Protocol from a channel.
+// Events are delivered in order, starting from the first call to Events, and
+// the channel is closed when the Protocol is closed. The channel is bounded:
+// if nobody reads it, the oldest pending event is dropped so the most recent
+// events are always available.
+func (p *Protocol) Events() <-chan Event {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.eventC == nil {
+		p.eventC = make(chan Event, eventBufferSize)
+		if p.closed {
+			close(p.eventC)
+		}
+	}
+
+	return p.eventC
+}
+
+// unlock releases p.mu, and then delivers the queued events to the
+// EventHandler, unless another goroutine (or a caller up the stack) is already
+// delivering them
+func (p *Protocol) unlock() {
+	if p.delivering {
+		p.mu.Unlock()
+		return
+	}
+
+	p.delivering = true
+	for len(p.queued) > 0 {
+		events, h := p.queued, p.EventHandler
+		p.queued = nil
+		p.mu.Unlock()
+
+		for _, e := range events {
+			if h != nil {
+				h.HandleEvent(e)
+			}
+		}
+
+		p.mu.Lock()
+	}
+
+	p.delivering = false
+	p.mu.Unlock()
+}
+
+func (p *Protocol) closeEvents() {
+	if p.closed {
+		return
+	}
+
+	p.closed = true
+	p.queued = nil
+	if p.eventC != nil {
+		close(p.eventC)
+	}
+}
+
+func (p *Protocol) event(d EventDetail) {
+	if p.closed {
+		return
+	}
+
+	if p.EventHandler != nil {
+		p.queued = append(p.queued, d)
+	}
+
+	if p.eventC == nil {
+		return
+	}
+
+	e := d.Kind()
+
+	select {
+	case p.eventC <- e:
+		return
+	default:
+	}
+
+	// the buffer is full: drop the oldest event
+	select {
+	case <-p.eventC:
+	default:
+	}
+
+	select {
+	case p.eventC <- e:
+	default:
+	}
+}
@@ -0,0 +1,118 @@
+package smp
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// FixedBaseTeeth is the number of teeth of the comb table MODP groups
+// precompute for their generator. The table holds 2^FixedBaseTeeth group
+// elements (12KiB for MODP1536 with the default of 6), and each
+// exponentiation of the generator takes about 1/FixedBaseTeeth of the
+// squarings of a generic one. Zero disables the table.
+//
+// Larger tables speed up public exponentiations further, but slow down
+// secret ones, which read the whole table at every step.
+//
+// It is read the first time a group exponentiates its generator, so it must
+// be set before any Protocol runs.
+var FixedBaseTeeth = 6
+
+// fixedBase is a Lim-Lee comb for a fixed base b in the Montgomery domain.
+// The exponent, of at most teeth*cols bits, is split in teeth rows of cols
+// bits. Column k of the exponent selects the entry whose bit t is the bit
+// t*cols+k of the exponent, and table[v] is the product of b^(2^(t*cols))
+// for every bit t set in v.
+//
+// Building the table takes as many squarings as a generic exponentiation, so
+// it only pays for bases used many times. The bases of a session (like g2 and
+// g3) are exponentiated at most three times, and don't get one.
+type fixedBase struct {
+	mt          *montgomery
+	teeth, cols int
+	table       [][]uint
+}
+
+func newFixedBase(mt *montgomery, b *big.Int, expBits, teeth int) *fixedBase {
+	n := len(mt.m)
+	fb := &fixedBase{
+		mt:    mt,
+		teeth: teeth,
+		cols:  (expBits + teeth - 1) / teeth,
+		table: make([][]uint, 1<<teeth),
+	}
+
+	// powers[t] = b^(2^(t*cols))
+	powers := make([][]uint, teeth)
+	cur := toLimbs(new(big.Int).Mod(b, mt.modulus), n)
+	mt.mul(cur, cur, mt.rr)
+	for t := range powers {
+		powers[t] = append([]uint(nil), cur...)
+		for k := 0; k < fb.cols; k++ {
+			mt.mul(cur, cur, cur)
+		}
+	}
+
+	fb.table[0] = append([]uint(nil), mt.one...)
+	for v := 1; v < len(fb.table); v++ {
+		t := bits.TrailingZeros(uint(v))
+		fb.table[v] = make([]uint, n)
+		mt.mul(fb.table[v], fb.table[v&^(1<<t)], powers[t])
+	}
+
+	return fb
+}
+
+// fits returns whether x is short enough for the comb
+func (fb *fixedBase) fits(x *big.Int) bool {
+	return x.Sign() >= 0 && x.BitLen() <= fb.teeth*fb.cols
+}
+
+// column returns the table index of the column k of the exponent e
+func (fb *fixedBase) column(e []uint, k int) uint {
+	var w uint
+	for t := 0; t < fb.teeth; t++ {
+		i := t*fb.cols + k
+		w |= (e[i/bits.UintSize] >> uint(i%bits.UintSize) & 1) << uint(t)
+	}
+
+	return w
+}
+
+// exp returns b^x mod m for a secret x. It reads every entry of the table at
+// each step, so its running time does not depend on x. x must fit.
+func (fb *fixedBase) exp(x *big.Int) *big.Int {
+	return fb.run(x, func(sel []uint, w uint) []uint {
+		selectLimbs(sel, fb.table, w)
+		return sel
+	})
+}
+
+// expVarTime returns b^x mod m for a public x. x must fit.
+func (fb *fixedBase) expVarTime(x *big.Int) *big.Int {
+	return fb.run(x, func(_ []uint, w uint) []uint {
+		return fb.table[w]
+	})
+}
+
+func (fb *fixedBase) run(x *big.Int, lookup func(sel []uint, w uint) []uint) *big.Int {
+	mt := fb.mt
+	n := len(mt.m)
+
+	e := toLimbs(x, (fb.teeth*fb.cols+bits.UintSize-1)/bits.UintSize)
+	acc := append([]uint(nil), mt.one...)
+	sel := make([]uint, n)
+	for k := fb.cols - 1; k >= 0; k-- {
+		mt.mul(acc, acc, acc)
+		mt.mul(acc, acc, lookup(sel, fb.column(e, k)))
+	}
+
+	// leave the Montgomery domain
+	one := make([]uint, n)
+	one[0] = 1
+	mt.mul(acc, acc, one)
+
+	wipeLimbs(e)
+	wipeLimbs(sel)
+	return fromLimbs(acc)
+}
@@ -0,0 +1,119 @@
+package smp
+
+import (
+	"crypto/rand"
+	"fmt"
+	"math/big"
+	"testing"
+)
+
+func TestFixedBaseMatchesMathBig(t *testing.T) {
+	mt := newMontgomery(P)
+
+	exps := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(2),
+		sub(Q, big.NewInt(1)),
+	}
+	for i := 0; i < 8; i++ {
+		x, _ := rand.Int(rand.Reader, Q)
+		exps = append(exps, x)
+	}
+
+	for _, teeth := range []int{1, 3, 8} {
+		fb := newFixedBase(mt, G1, Q.BitLen(), teeth)
+
+		for _, x := range exps {
+			expected := modExp(G1, x, P)
+
+			if got := fb.exp(x); !eq(got, expected) {
+				t.Errorf("teeth=%d: exp(%x) = %x, expected %x", teeth, x, got, expected)
+			}
+
+			if got := fb.expVarTime(x); !eq(got, expected) {
+				t.Errorf("teeth=%d: expVarTime(%x) = %x, expected %x", teeth, x, got, expected)
+			}
+		}
+	}
+}
+
+func TestFixedBaseRejectsLongExponents(t *testing.T) {
+	fb := newFixedBase(newMontgomery(P), G1, Q.BitLen(), 8)
+
+	if !fb.fits(sub(Q, big.NewInt(1))) {
+		t.Errorf("q-1 should fit")
+	}
+
+	if fb.fits(new(big.Int).Lsh(big.NewInt(1), uint(fb.teeth*fb.cols))) {
+		t.Errorf("2^(teeth*cols) should not fit")
+	}
+
+	// a group falls back to a generic exponentiation
+	x := new(big.Int).Lsh(P, 3)
+	if got := MODP1536.Exp(G1, x); !eq(got, modExp(G1, x, P)) {
+		t.Errorf("Exp with a long exponent is wrong")
+	}
+
+	if got := expSecret(MODP1536, G1, x); !eq(got, modExp(G1, x, P)) {
+		t.Errorf("ExpSecret with a long exponent is wrong")
+	}
+}
+
+func TestFixedBaseCanBeDisabled(t *testing.T) {
+	defer func(teeth int) { FixedBaseTeeth = teeth }(FixedBaseTeeth)
+	FixedBaseTeeth = 0
+
+	g := NewMODPGroup(P, Q, G1).(*modpGroup)
+	x := big.NewInt(12345)
+
+	if got := g.Exp(G1, x); !eq(got, modExp(G1, x, P)) {
+		t.Errorf("Exp without a table is wrong")
+	}
+
+	if g.comb != nil {
+		t.Errorf("no table should be built")
+	}
+}
+
+func BenchmarkGeneratorExp(b *testing.B) {
+	x, _ := rand.Int(rand.Reader, Q)
+	mt := newMontgomery(P)
+
+	b.Run("math/big", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			modExp(G1, x, P)
+		}
+	})
+
+	b.Run("montgomery", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			mt.exp(G1, x)
+		}
+	})
+
+	for _, teeth := range []int{4, 6, 8, 10} {
+		fb := newFixedBase(mt, G1, Q.BitLen(), teeth)
+		size := float64(len(fb.table) * len(mt.m) * 8)
+
+		b.Run(fmt.Sprintf("comb%d/secret", teeth), func(b *testing.B) {
+			b.ReportMetric(size, "table-bytes")
+			for i := 0; i < b.N; i++ {
+				fb.exp(x)
+			}
+		})
+
+		b.Run(fmt.Sprintf("comb%d/public", teeth), func(b *testing.B) {
+			b.ReportMetric(size, "table-bytes")
+			for i := 0; i < b.N; i++ {
+				fb.expVarTime(x)
+			}
+		})
+	}
+
+	b.Run("build/comb8", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			newFixedBase(mt, G1, Q.BitLen(), 8)
+		}
+	})
+}
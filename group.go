@@ -1,12 +1,19 @@
 package smp
 
-import "math/big"
+import (
+	"errors"
+	"math/big"
+	"sync"
+)
 
 var (
 	P *big.Int // prime field, defined in RFC3526 as Diffie-Hellman Group 5
 	//pMinusTwo *big.Int
 	Q  *big.Int // prime order
 	G1 *big.Int // group generator
+
+	// MODP1536 is the RFC3526 1536-bit MODP group used by OTRv3
+	MODP1536 Group
 )
 
 func init() {
@@ -32,4 +39,205 @@ func init() {
 
 	//pMinusTwo = sub(P, big.NewInt(2))
 	G1 = big.NewInt(2)
+
+	MODP1536 = NewMODPGroup(P, Q, G1)
+}
+
+var errInvalidGroupElement = errors.New("invalid group element")
+
+// Group represents the prime order group the SMP protocol runs over.
+// Both group elements and exponents are represented as *big.Int. Groups whose
+// elements are not integers (like elliptic curves) represent an element by
+// its canonical encoding interpreted as a big-endian integer. A Group must be
+// safe for concurrent use, and must not panic on elements accepted by
+// IsElement.
+type Group interface {
+	// Generator returns the group generator
+	Generator() *big.Int
+	// Order returns the order of the group
+	Order() *big.Int
+	// Identity returns the identity element
+	Identity() *big.Int
+
+	// Exp returns g^x
+	Exp(g, x *big.Int) *big.Int
+	// Mul returns l*r
+	Mul(l, r *big.Int) *big.Int
+	// Inverse returns g^-1
+	Inverse(g *big.Int) *big.Int
+
+	// IsElement returns whether g is a valid element of the group
+	IsElement(g *big.Int) bool
+
+	// Encode returns the canonical encoding of the element g
+	Encode(g *big.Int) []byte
+	// Decode returns the element encoded in b
+	Decode(b []byte) (*big.Int, error)
+}
+
+// SecretExponentiator is implemented by groups that provide a dedicated
+// exponentiation for secret exponents, like a constant time one. Groups that
+// don't implement it should make Exp constant time.
+type SecretExponentiator interface {
+	// ExpSecret returns g^x for a secret x
+	ExpSecret(g, x *big.Int) *big.Int
+}
+
+// expSecret returns g^x in the group, where x is secret
+func expSecret(g Group, b, x *big.Int) *big.Int {
+	if s, ok := g.(SecretExponentiator); ok {
+		return s.ExpSecret(b, x)
+	}
+
+	return g.Exp(b, x)
+}
+
+// MultiExponentiator is implemented by groups that compute a product of
+// powers faster than one exponentiation per term, like Straus' or Shamir's
+// simultaneous multi-exponentiation. It is only used to verify proofs, where
+// every exponent is public, so it may run in variable time.
+type MultiExponentiator interface {
+	// MultiExp returns the product of bases[i]^exps[i]
+	MultiExp(bases, exps []*big.Int) *big.Int
+}
+
+// multiExp returns the product of bases[i]^exps[i] in the group, where the
+// exponents are public
+func multiExp(g Group, bases, exps []*big.Int) *big.Int {
+	if m, ok := g.(MultiExponentiator); ok {
+		return m.MultiExp(bases, exps)
+	}
+
+	ret := g.Exp(bases[0], exps[0])
+	for i := 1; i < len(bases); i++ {
+		ret = g.Mul(ret, g.Exp(bases[i], exps[i]))
+	}
+
+	return ret
+}
+
+type modpGroup struct {
+	p, q, g *big.Int
+	pMinus2 *big.Int
+	mont    *montgomery
+
+	// comb is the fixed-base table of g, built on first use
+	combOnce sync.Once
+	comb     *fixedBase
+}
+
+// NewMODPGroup returns the order q subgroup of the multiplicative group of
+// integers modulo the safe prime p, generated by g
+func NewMODPGroup(p, q, g *big.Int) Group {
+	return &modpGroup{
+		p:       p,
+		q:       q,
+		g:       g,
+		pMinus2: sub(p, big.NewInt(2)),
+		mont:    newMontgomery(p),
+	}
+}
+
+func (g *modpGroup) Generator() *big.Int {
+	return g.g
+}
+
+func (g *modpGroup) Order() *big.Int {
+	return g.q
+}
+
+func (g *modpGroup) Identity() *big.Int {
+	return big.NewInt(1)
+}
+
+func (g *modpGroup) Exp(b, x *big.Int) *big.Int {
+	if c := g.generatorComb(b, x); c != nil {
+		return c.expVarTime(x)
+	}
+
+	return modExp(b, x, g.p)
+}
+
+// ExpSecret returns b^x (mod p) using the SecretExpBackend. The generator
+// uses its fixed-base table with the ConstantTimeExp backend.
+func (g *modpGroup) ExpSecret(b, x *big.Int) *big.Int {
+	if SecretExpBackend == ConstantTimeExp {
+		if c := g.generatorComb(b, x); c != nil {
+			return c.exp(x)
+		}
+	}
+
+	return modExpSecret(b, x, g.mont)
+}
+
+// generatorComb returns the fixed-base table of the generator if b is the
+// generator and x fits in it, or nil
+func (g *modpGroup) generatorComb(b, x *big.Int) *fixedBase {
+	if !eq(b, g.g) {
+		return nil
+	}
+
+	g.combOnce.Do(func() {
+		if FixedBaseTeeth > 0 {
+			g.comb = newFixedBase(g.mont, g.g, g.q.BitLen(), FixedBaseTeeth)
+		}
+	})
+
+	if g.comb == nil || !g.comb.fits(x) {
+		return nil
+	}
+
+	return g.comb
+}
+
+// MultiExp implements MultiExponentiator with Straus' simultaneous
+// exponentiation. The exponents are reduced modulo q, which does not change
+// the result for elements of the group.
+func (g *modpGroup) MultiExp(bases, exps []*big.Int) *big.Int {
+	reduced := make([]*big.Int, len(exps))
+	for i, x := range exps {
+		reduced[i] = new(big.Int).Mod(x, g.q)
+	}
+
+	return g.mont.multiExp(bases, reduced)
+}
+
+func (g *modpGroup) Mul(l, r *big.Int) *big.Int {
+	return mulMod(l, r, g.p)
+}
+
+func (g *modpGroup) Inverse(b *big.Int) *big.Int {
+	return modInverse(b, g.p)
+}
+
+// IsElement checks 2 <= x <= p-2 and x^q = 1 (mod p)
+func (g *modpGroup) IsElement(x *big.Int) bool {
+	if !g.inRange(x) {
+		return false
+	}
+
+	return eq(modExp(x, g.q, g.p), big.NewInt(1))
+}
+
+// inRange checks 2 <= x <= p-2
+func (g *modpGroup) inRange(x *big.Int) bool {
+	return gte(x, big.NewInt(2)) && lte(x, g.pMinus2)
+}
+
+func (g *modpGroup) Encode(x *big.Int) []byte {
+	return x.Bytes()
+}
+
+func (g *modpGroup) Decode(b []byte) (*big.Int, error) {
+	x := new(big.Int).SetBytes(b)
+	if !g.IsElement(x) {
+		return nil, errInvalidGroupElement
+	}
+
+	return x, nil
+}
+
+// div returns l/r in the group g
+func div(g Group, l, r *big.Int) *big.Int {
+	return g.Mul(l, g.Inverse(r))
 }
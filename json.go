@@ -0,0 +1,302 @@
+package smp
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"strconv"
+	"unicode/utf16"
+	"unicode/utf8"
+)
+
+// jsonMPI is the encoding of MPIs in JSON: the big-endian bytes, without
+// leading zeros, in unpadded base64url
+var jsonMPI = base64.RawURLEncoding.Strict()
+
+// jsonTypes are the values of the "type" discriminator
+var jsonTypes = map[byte]string{
+	wireSMP1:     "SMP1",
+	wireSMP1Q:    "SMP1Q",
+	wireSMP2:     "SMP2",
+	wireSMP3:     "SMP3",
+	wireSMP4:     "SMP4",
+	wireSMPAbort: "SMPAbort",
+}
+
+// jsonFields are the names of the MPIs of each type, as in the spec and in
+// the order of MPIs()
+var jsonFields = map[byte][]string{
+	wireSMP1:     {"g2a", "c2", "d2", "g3a", "c3", "d3"},
+	wireSMP1Q:    {"g2a", "c2", "d2", "g3a", "c3", "d3"},
+	wireSMP2:     {"g2b", "c2", "d2", "g3b", "c3", "d3", "pb", "qb", "cp", "d5", "d6"},
+	wireSMP3:     {"pa", "qa", "cp", "d5", "d6", "ra", "cr", "d7"},
+	wireSMP4:     {"rb", "cr", "d7"},
+	wireSMPAbort: {},
+}
+
+// marshalMessageJSON encodes a message as a JSON object with its type, the
+// question of an SMP1Q, and one field per MPI
+func marshalMessageJSON(t byte, question string, mpis []*big.Int) ([]byte, error) {
+	if err := checkMPIs(mpis); err != nil {
+		return nil, err
+	}
+
+	var b bytes.Buffer
+
+	b.WriteString(`{"type":"` + jsonTypes[t] + `"`)
+	if t == wireSMP1Q {
+		q, err := json.Marshal(question)
+		if err != nil {
+			return nil, err
+		}
+
+		b.WriteString(`,"question":`)
+		b.Write(q)
+	}
+
+	for i, name := range jsonFields[t] {
+		b.WriteString(`,"` + name + `":"` + jsonMPI.EncodeToString(mpis[i].Bytes()) + `"`)
+	}
+	b.WriteString("}")
+
+	return b.Bytes(), nil
+}
+
+// UnmarshalMessageJSON decodes a message encoded by its MarshalJSON method.
+// It returns the concrete message named by the "type" field.
+func UnmarshalMessageJSON(data []byte) (Message, error) {
+	return unmarshalMessageJSON(data, 0)
+}
+
+// unmarshalMessageJSON decodes a JSON message, of type want if not zero
+func unmarshalMessageJSON(data []byte, want byte) (Message, error) {
+	var fields map[string]json.RawMessage
+	if err := json.Unmarshal(data, &fields); err != nil {
+		return nil, malformed("invalid JSON: " + err.Error())
+	}
+
+	name, ok := jsonString(fields, "type")
+	if !ok {
+		return nil, malformed("missing type")
+	}
+
+	t, ok := jsonType(name)
+	if !ok {
+		return nil, malformed("unknown message type " + name)
+	}
+
+	if want != 0 && t != want {
+		return nil, malformed("unexpected message type " + name)
+	}
+
+	var question string
+	if t == wireSMP1Q {
+		raw := fields["question"]
+		if question, ok = jsonString(fields, "question"); !ok {
+			return nil, malformed("missing question")
+		}
+
+		// encoding/json replaces invalid UTF-8 instead of failing
+		if !jsonValidUTF8(raw) {
+			return nil, malformed("question is not valid UTF-8")
+		}
+	}
+
+	values := make([][]byte, len(jsonFields[t]))
+	for i, field := range jsonFields[t] {
+		s, ok := jsonString(fields, field)
+		if !ok {
+			return nil, malformed("missing " + field)
+		}
+
+		var err error
+		if values[i], err = jsonMPI.DecodeString(s); err != nil {
+			return nil, malformed(field + " is not base64url")
+		}
+	}
+
+	for field := range fields {
+		return nil, malformed("unknown field " + field)
+	}
+
+	mpis, err := messageMPIs(t, values)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMessage(t, question, mpis)
+}
+
+// jsonString removes the field from fields and returns its value, if it is
+// a string
+func jsonString(fields map[string]json.RawMessage, field string) (string, bool) {
+	raw, ok := fields[field]
+	delete(fields, field)
+
+	var s string
+	if !ok || json.Unmarshal(raw, &s) != nil || bytes.Equal(raw, []byte("null")) {
+		return "", false
+	}
+
+	return s, true
+}
+
+// jsonValidUTF8 checks that the JSON string raw holds valid UTF-8, both in
+// its bytes and in its \u escapes, where a surrogate must be part of a pair
+func jsonValidUTF8(raw []byte) bool {
+	if !utf8.Valid(raw) {
+		return false
+	}
+
+	for i := 0; i < len(raw); i++ {
+		if raw[i] != '\\' {
+			continue
+		}
+
+		i++
+		if i >= len(raw) || raw[i] != 'u' {
+			continue
+		}
+
+		r, ok := jsonEscape(raw[i-1:])
+		if !ok {
+			return false
+		}
+		i += 4
+
+		switch {
+		case utf16.IsSurrogate(r) && r < 0xdc00:
+			low, ok := jsonEscape(raw[i+1:])
+			if !ok || utf16.DecodeRune(r, low) == utf8.RuneError {
+				return false
+			}
+			i += 6
+		case utf16.IsSurrogate(r):
+			return false
+		}
+	}
+
+	return true
+}
+
+// jsonEscape decodes the \uXXXX escape at the start of b
+func jsonEscape(b []byte) (rune, bool) {
+	if len(b) < 6 || b[0] != '\\' || b[1] != 'u' {
+		return 0, false
+	}
+
+	r, err := strconv.ParseUint(string(b[2:6]), 16, 16)
+	if err != nil {
+		return 0, false
+	}
+
+	return rune(r), true
+}
+
+func jsonType(name string) (byte, bool) {
+	for t, n := range jsonTypes {
+		if n == name {
+			return t, true
+		}
+	}
+
+	return 0, false
+}
+
+// MarshalJSON encodes the message as the JSON object read by
+// UnmarshalMessageJSON
+func (m SMP1) MarshalJSON() ([]byte, error) {
+	return marshalMessageJSON(wireSMP1, "", m.MPIs())
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON
+func (m *SMP1) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalMessageJSON(data, wireSMP1)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP1)
+	return nil
+}
+
+// MarshalJSON encodes the message as the JSON object read by
+// UnmarshalMessageJSON
+func (m SMP1Q) MarshalJSON() ([]byte, error) {
+	return marshalMessageJSON(wireSMP1Q, m.question, m.MPIs())
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON
+func (m *SMP1Q) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalMessageJSON(data, wireSMP1Q)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP1Q)
+	return nil
+}
+
+// MarshalJSON encodes the message as the JSON object read by
+// UnmarshalMessageJSON
+func (m SMP2) MarshalJSON() ([]byte, error) {
+	return marshalMessageJSON(wireSMP2, "", m.MPIs())
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON
+func (m *SMP2) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalMessageJSON(data, wireSMP2)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP2)
+	return nil
+}
+
+// MarshalJSON encodes the message as the JSON object read by
+// UnmarshalMessageJSON
+func (m SMP3) MarshalJSON() ([]byte, error) {
+	return marshalMessageJSON(wireSMP3, "", m.MPIs())
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON
+func (m *SMP3) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalMessageJSON(data, wireSMP3)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP3)
+	return nil
+}
+
+// MarshalJSON encodes the message as the JSON object read by
+// UnmarshalMessageJSON
+func (m SMP4) MarshalJSON() ([]byte, error) {
+	return marshalMessageJSON(wireSMP4, "", m.MPIs())
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON
+func (m *SMP4) UnmarshalJSON(data []byte) error {
+	v, err := unmarshalMessageJSON(data, wireSMP4)
+	if err != nil {
+		return err
+	}
+
+	*m = v.(SMP4)
+	return nil
+}
+
+// MarshalJSON encodes the message as the JSON object read by
+// UnmarshalMessageJSON
+func (m SMPAbort) MarshalJSON() ([]byte, error) {
+	return marshalMessageJSON(wireSMPAbort, "", nil)
+}
+
+// UnmarshalJSON decodes a message encoded by MarshalJSON
+func (m *SMPAbort) UnmarshalJSON(data []byte) error {
+	_, err := unmarshalMessageJSON(data, wireSMPAbort)
+	return err
+}
@@ -0,0 +1,115 @@
+package smp
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestMessagesRoundTripThroughJSON(t *testing.T) {
+	msgs := seededRun("alice json seed", "bob json seed")
+	q, _ := NewSMP1Q("what is \"the\" answer?", msgs[0].MPIs()...)
+	msgs = append(msgs, *q, SMPAbort{})
+
+	for _, m := range msgs {
+		data, err := json.Marshal(m)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := UnmarshalMessageJSON(data)
+		if err != nil {
+			t.Fatalf("%s: %v", messageType(m), err)
+		}
+
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s: decoded %#v", messageType(m), got)
+		}
+
+		// the concrete type decodes its own encoding
+		dest := reflect.New(reflect.TypeOf(m))
+		if err := json.Unmarshal(data, dest.Interface()); err != nil {
+			t.Errorf("%s: %v", messageType(m), err)
+		}
+
+		if !reflect.DeepEqual(dest.Elem().Interface(), m) {
+			t.Errorf("%s: UnmarshalJSON decoded %#v", messageType(m), dest.Interface())
+		}
+	}
+}
+
+func TestJSONFormat(t *testing.T) {
+	m, _ := NewSMP4(big.NewInt(0xfbff), big.NewInt(0), big.NewInt(1))
+	data, _ := json.Marshal(m)
+
+	expected := `{"type":"SMP4","rb":"-_8","cr":"","d7":"AQ"}`
+	if string(data) != expected {
+		t.Errorf("got %s, expected %s", data, expected)
+	}
+
+	q, _ := NewSMP1Q("?", big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4), big.NewInt(5), big.NewInt(6))
+	data, _ = json.Marshal(q)
+
+	expected = `{"type":"SMP1Q","question":"?","g2a":"AQ","c2":"Ag","d2":"Aw","g3a":"BA","c3":"BQ","d3":"Bg"}`
+	if string(data) != expected {
+		t.Errorf("got %s, expected %s", data, expected)
+	}
+}
+
+func TestMalformedJSONMessagesAreRejected(t *testing.T) {
+	cases := map[string]string{
+		"not an object":  `[]`,
+		"no type":        `{"rb":"AQ","cr":"AQ","d7":"AQ"}`,
+		"unknown type":   `{"type":"SMP5"}`,
+		"missing field":  `{"type":"SMP4","rb":"AQ","cr":"AQ"}`,
+		"null field":     `{"type":"SMP4","rb":"AQ","cr":"AQ","d7":null}`,
+		"unknown field":  `{"type":"SMP4","rb":"AQ","cr":"AQ","d7":"AQ","d8":"AQ"}`,
+		"number":         `{"type":"SMP4","rb":1,"cr":"AQ","d7":"AQ"}`,
+		"padding":        `{"type":"SMP4","rb":"AQ==","cr":"AQ","d7":"AQ"}`,
+		"standard b64":   `{"type":"SMP4","rb":"+/8","cr":"AQ","d7":"AQ"}`,
+		"leading zeros":  `{"type":"SMP4","rb":"AAE","cr":"AQ","d7":"AQ"}`,
+		"no question":    `{"type":"SMP1Q","g2a":"AQ","c2":"Ag","d2":"Aw","g3a":"BA","c3":"BQ","d3":"Bg"}`,
+		"question in 1":  `{"type":"SMP1","question":"?","g2a":"AQ","c2":"Ag","d2":"Aw","g3a":"BA","c3":"BQ","d3":"Bg"}`,
+		"abort with mpi": `{"type":"SMPAbort","rb":"AQ"}`,
+		"invalid utf-8":  "{\"type\":\"SMP1Q\",\"question\":\"\xff\"" + `,"g2a":"AQ","c2":"Ag","d2":"Aw","g3a":"BA","c3":"BQ","d3":"Bg"}`,
+		"lone surrogate": `{"type":"SMP1Q","question":"\ud83d?","g2a":"AQ","c2":"Ag","d2":"Aw","g3a":"BA","c3":"BQ","d3":"Bg"}`,
+		"low surrogate":  `{"type":"SMP1Q","question":"\ude00","g2a":"AQ","c2":"Ag","d2":"Aw","g3a":"BA","c3":"BQ","d3":"Bg"}`,
+	}
+
+	for name, data := range cases {
+		_, err := UnmarshalMessageJSON([]byte(data))
+
+		var me *MalformedMessageError
+		if !errors.As(err, &me) {
+			t.Errorf("%s: expected a MalformedMessageError, got %v", name, err)
+		}
+	}
+
+	var m SMP3
+	if err := json.Unmarshal([]byte(`{"type":"SMP4","rb":"AQ","cr":"AQ","d7":"AQ"}`), &m); err == nil {
+		t.Errorf("SMP3 should not decode an SMP4")
+	}
+}
+
+func TestEscapedQuestionsAreDecoded(t *testing.T) {
+	data := `{"type":"SMP1Q","question":"\\u\ud83d\ude00\ufffd","g2a":"AQ","c2":"Ag","d2":"Aw","g3a":"BA","c3":"BQ","d3":"Bg"}`
+
+	m, err := UnmarshalMessageJSON([]byte(data))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q := m.(SMP1Q).question; q != "\\u\U0001F600\uFFFD" {
+		t.Errorf("unexpected question %q", q)
+	}
+}
+
+func TestZeroValueMessagesAreNotEncodedInJSON(t *testing.T) {
+	for _, m := range []json.Marshaler{SMP1{}, SMP1Q{}, SMP2{}, SMP3{}, SMP4{}} {
+		if _, err := json.Marshal(m); !errors.Is(err, errMissingMPI) {
+			t.Errorf("%T: expected %v, got %v", m, errMissingMPI, err)
+		}
+	}
+}
@@ -0,0 +1,225 @@
+package smp
+
+import (
+	"math/big"
+	"math/bits"
+)
+
+// expWindow is the window size, in bits, of the fixed-window exponentiation
+const expWindow = 4
+
+// montgomery implements constant time arithmetic modulo an odd modulus using
+// Montgomery multiplication over fixed-width limbs. Every value has exactly as
+// many limbs as the modulus, and no branch or memory access depends on the
+// value of the operands.
+type montgomery struct {
+	modulus *big.Int
+	m       []uint
+	m0inv   uint   // -m^-1 mod 2^W
+	rr      []uint // R^2 mod m
+	one     []uint // R mod m, which is 1 in the Montgomery domain
+}
+
+func newMontgomery(m *big.Int) *montgomery {
+	n := len(m.Bits())
+	mt := &montgomery{
+		modulus: m,
+		m:       toLimbs(m, n),
+	}
+
+	// Newton iteration for m^-1 mod 2^W
+	inv := uint(1)
+	for i := 0; i < bits.UintSize; i++ {
+		inv *= 2 - mt.m[0]*inv
+	}
+	mt.m0inv = -inv
+
+	r := new(big.Int).Lsh(big.NewInt(1), uint(n*bits.UintSize))
+	mt.one = toLimbs(new(big.Int).Mod(r, m), n)
+	mt.rr = toLimbs(new(big.Int).Exp(r, big.NewInt(2), m), n)
+
+	return mt
+}
+
+func toLimbs(x *big.Int, n int) []uint {
+	z := make([]uint, n)
+	for i, w := range x.Bits() {
+		z[i] = uint(w)
+	}
+
+	return z
+}
+
+func fromLimbs(z []uint) *big.Int {
+	w := make([]big.Word, len(z))
+	for i := range z {
+		w[i] = big.Word(z[i])
+	}
+
+	return new(big.Int).SetBits(w)
+}
+
+// mul sets z = x*y*R^-1 mod m. x and y must be reduced modulo m.
+func (mt *montgomery) mul(z, x, y []uint) {
+	n := len(mt.m)
+	t := make([]uint, n+2)
+
+	for i := 0; i < n; i++ {
+		// t += x*y[i]
+		var c uint
+		for j := 0; j < n; j++ {
+			hi, lo := bits.Mul(x[j], y[i])
+			lo, c1 := bits.Add(lo, t[j], 0)
+			lo, c2 := bits.Add(lo, c, 0)
+			t[j], c = lo, hi+c1+c2
+		}
+		t[n], c = bits.Add(t[n], c, 0)
+		t[n+1] = c
+
+		// t = (t + u*m) / 2^W
+		u := t[0] * mt.m0inv
+		hi, lo := bits.Mul(u, mt.m[0])
+		_, c = bits.Add(lo, t[0], 0)
+		c += hi
+		for j := 1; j < n; j++ {
+			hi, lo := bits.Mul(u, mt.m[j])
+			lo, c1 := bits.Add(lo, t[j], 0)
+			lo, c2 := bits.Add(lo, c, 0)
+			t[j-1], c = lo, hi+c1+c2
+		}
+		t[n-1], c = bits.Add(t[n], c, 0)
+		t[n] = t[n+1] + c
+	}
+
+	// t < 2m, subtract m if t >= m
+	var b uint
+	for j := 0; j < n; j++ {
+		z[j], b = bits.Sub(t[j], mt.m[j], b)
+	}
+	_, b = bits.Sub(t[n], 0, b)
+
+	// b == 0 means t >= m, and z already holds t-m
+	keep := -b
+	for j := 0; j < n; j++ {
+		z[j] = (t[j] & keep) | (z[j] &^ keep)
+	}
+}
+
+// exp returns g^x mod m. Its running time depends only on the length of the
+// modulus (or on the length of x, if it is longer than the modulus).
+func (mt *montgomery) exp(g, x *big.Int) *big.Int {
+	n := len(mt.m)
+
+	base := toLimbs(new(big.Int).Mod(g, mt.modulus), n)
+	mt.mul(base, base, mt.rr)
+
+	table := make([][]uint, 1<<expWindow)
+	table[0] = append([]uint(nil), mt.one...)
+	for i := 1; i < len(table); i++ {
+		table[i] = make([]uint, n)
+		mt.mul(table[i], table[i-1], base)
+	}
+
+	words := n
+	if l := len(x.Bits()); l > words {
+		words = l
+	}
+	e := toLimbs(x, words)
+
+	acc := append([]uint(nil), mt.one...)
+	sel := make([]uint, n)
+	for i := words*bits.UintSize - expWindow; i >= 0; i -= expWindow {
+		for j := 0; j < expWindow; j++ {
+			mt.mul(acc, acc, acc)
+		}
+
+		w := (e[i/bits.UintSize] >> uint(i%bits.UintSize)) & (1<<expWindow - 1)
+		selectLimbs(sel, table, w)
+		mt.mul(acc, acc, sel)
+	}
+
+	// leave the Montgomery domain
+	one := make([]uint, n)
+	one[0] = 1
+	mt.mul(acc, acc, one)
+
+	wipeLimbs(e)
+	return fromLimbs(acc)
+}
+
+// multiExp returns the product of bases[i]^exps[i] mod m with Straus'
+// simultaneous exponentiation: every term shares the same squarings, and
+// multiplies in one entry of its window table per window. It runs in
+// variable time, so the exponents must be public. They must not be negative.
+func (mt *montgomery) multiExp(bases, exps []*big.Int) *big.Int {
+	n := len(mt.m)
+
+	words := 0
+	for _, x := range exps {
+		if l := len(x.Bits()); l > words {
+			words = l
+		}
+	}
+
+	tables := make([][][]uint, len(bases))
+	es := make([][]uint, len(exps))
+	for i, b := range bases {
+		base := toLimbs(new(big.Int).Mod(b, mt.modulus), n)
+		mt.mul(base, base, mt.rr)
+
+		tables[i] = make([][]uint, 1<<expWindow)
+		tables[i][1] = base
+		for k := 2; k < len(tables[i]); k++ {
+			tables[i][k] = make([]uint, n)
+			mt.mul(tables[i][k], tables[i][k-1], base)
+		}
+
+		es[i] = toLimbs(exps[i], words)
+	}
+
+	acc := append([]uint(nil), mt.one...)
+	for i := words*bits.UintSize - expWindow; i >= 0; i -= expWindow {
+		for j := 0; j < expWindow; j++ {
+			mt.mul(acc, acc, acc)
+		}
+
+		for k, e := range es {
+			if w := (e[i/bits.UintSize] >> uint(i%bits.UintSize)) & (1<<expWindow - 1); w != 0 {
+				mt.mul(acc, acc, tables[k][w])
+			}
+		}
+	}
+
+	// leave the Montgomery domain
+	one := make([]uint, n)
+	one[0] = 1
+	mt.mul(acc, acc, one)
+
+	return fromLimbs(acc)
+}
+
+// selectLimbs sets z = table[w] reading every entry of the table
+func selectLimbs(z []uint, table [][]uint, w uint) {
+	for j := range z {
+		z[j] = 0
+	}
+
+	for k := range table {
+		mask := ctEqMask(uint(k), w)
+		for j := range z {
+			z[j] |= table[k][j] & mask
+		}
+	}
+}
+
+// ctEqMask returns all ones if a == b, and zero otherwise
+func ctEqMask(a, b uint) uint {
+	x := a ^ b
+	return ((x | -x) >> (bits.UintSize - 1)) - 1
+}
+
+func wipeLimbs(z []uint) {
+	for i := range z {
+		z[i] = 0
+	}
+}
@@ -0,0 +1,86 @@
+package smp
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+func TestMontgomeryExpMatchesMathBig(t *testing.T) {
+	mt := newMontgomery(P)
+
+	exponents := []*big.Int{
+		big.NewInt(0),
+		big.NewInt(1),
+		big.NewInt(2),
+		Q,
+		sub(P, big.NewInt(1)),
+		new(big.Int).Lsh(P, 70), // longer than the modulus
+	}
+
+	for i := 0; i < 20; i++ {
+		x, _ := rand.Int(rand.Reader, P)
+		exponents = append(exponents, x)
+	}
+
+	bases := []*big.Int{G1, big.NewInt(1), sub(P, big.NewInt(1)), P, new(big.Int).Add(P, big.NewInt(3))}
+	for i := 0; i < 5; i++ {
+		b, _ := rand.Int(rand.Reader, P)
+		bases = append(bases, b)
+	}
+
+	for _, b := range bases {
+		for _, x := range exponents {
+			if !eq(mt.exp(b, x), modExp(b, x, P)) {
+				t.Fatalf("%x^%x differs from math/big", b, x)
+			}
+		}
+	}
+}
+
+func TestSecretExpBackendsAgree(t *testing.T) {
+	defer func(b ExpBackend) { SecretExpBackend = b }(SecretExpBackend)
+
+	x, _ := rand.Int(rand.Reader, Q)
+
+	SecretExpBackend = ConstantTimeExp
+	ct := expSecret(MODP1536, G1, x)
+
+	SecretExpBackend = VariableTimeExp
+	vt := expSecret(MODP1536, G1, x)
+
+	if !eq(ct, vt) {
+		t.Errorf("backends disagree")
+	}
+}
+
+func TestCtEq(t *testing.T) {
+	if !ctEq(big.NewInt(0x0102), big.NewInt(0x0102)) {
+		t.Errorf("equal values should compare equal")
+	}
+
+	if ctEq(big.NewInt(0x02), big.NewInt(0x0102)) {
+		t.Errorf("values of different length should differ")
+	}
+
+	if ctEq(big.NewInt(0x0103), big.NewInt(0x0102)) {
+		t.Errorf("different values should differ")
+	}
+}
+
+func BenchmarkSecretExp(b *testing.B) {
+	x, _ := rand.Int(rand.Reader, Q)
+
+	b.Run("montgomery", func(b *testing.B) {
+		mt := newMontgomery(P)
+		for i := 0; i < b.N; i++ {
+			mt.exp(G1, x)
+		}
+	})
+
+	b.Run("math/big", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			modExp(G1, x, P)
+		}
+	})
+}
@@ -0,0 +1,79 @@
+package smp
+
+import (
+	"hash"
+	"math/big"
+)
+
+type modpOptions struct {
+	group *modpGroup
+
+	rangeOnly bool
+}
+
+// DefaultOptions returns the Options for the RFC3526 1536-bit group with the
+// checks required by the spec: every group element must satisfy 2 <= x <= p-2
+// and belong to the subgroup of order q.
+func DefaultOptions() Options {
+	return &modpOptions{group: MODP1536.(*modpGroup)}
+}
+
+// RangeCheckOptions returns the Options for the RFC3526 1536-bit group that
+// only check 2 <= x <= p-2, like libotr does. It skips one exponentiation per
+// received group element.
+func RangeCheckOptions() Options {
+	return &modpOptions{group: MODP1536.(*modpGroup), rangeOnly: true}
+}
+
+// ParameterLength is the length of q.
+//
+// Deprecated: ParameterLength is ignored, see Options.
+func (o *modpOptions) ParameterLength() int {
+	return (o.group.q.BitLen() + 7) / 8
+}
+
+func (o *modpOptions) IsGroupElement(x *big.Int) bool {
+	if o.rangeOnly {
+		return o.group.inRange(x)
+	}
+
+	return o.group.IsElement(x)
+}
+
+func (o *modpOptions) Group() Group {
+	return o.group
+}
+
+type hashOptions struct {
+	Options
+	hash   func() hash.Hash
+	prefix []byte
+}
+
+// WithProofHash returns Options like o, whose zero knowledge proofs are
+// computed with h over prefix || index || MPIs instead of SHA-256 over
+// index || MPIs.
+func WithProofHash(o Options, h func() hash.Hash, prefix []byte) Options {
+	return &hashOptions{
+		Options: o,
+		hash:    h,
+		prefix:  append([]byte(nil), prefix...),
+	}
+}
+
+func (o *hashOptions) ProofHash() func() hash.Hash {
+	return o.hash
+}
+
+func (o *hashOptions) DomainSeparation() []byte {
+	return o.prefix
+}
+
+// Group keeps the group selected by the wrapped Options
+func (o *hashOptions) Group() Group {
+	if g, ok := o.Options.(GroupOptions); ok {
+		return g.Group()
+	}
+
+	return MODP1536
+}
@@ -0,0 +1,83 @@
+package smp
+
+import (
+	"crypto/sha256"
+	"crypto/sha512"
+	"math/big"
+	"testing"
+)
+
+func TestDefaultOptionsRejectElementsOutsideTheSubgroup(t *testing.T) {
+	// p-2 = -2 is not a quadratic residue, so it is not in the order q subgroup
+	outside := sub(P, big.NewInt(2))
+
+	if DefaultOptions().IsGroupElement(outside) {
+		t.Errorf("DefaultOptions should reject elements outside the subgroup")
+	}
+
+	if !RangeCheckOptions().IsGroupElement(outside) {
+		t.Errorf("RangeCheckOptions should only check the range")
+	}
+
+	for _, o := range []Options{DefaultOptions(), RangeCheckOptions()} {
+		for _, x := range []*big.Int{big.NewInt(1), sub(P, big.NewInt(1)), P} {
+			if o.IsGroupElement(x) {
+				t.Errorf("%x should not be a group element", x)
+			}
+		}
+
+		if !o.IsGroupElement(modExp(G1, big.NewInt(1234), P)) {
+			t.Errorf("g^x should be a group element")
+		}
+
+		if o.ParameterLength() != 192 {
+			t.Errorf("expected 192 bytes exponents, got %d", o.ParameterLength())
+		}
+	}
+}
+
+func TestDefaultProofHashIsOTRv3(t *testing.T) {
+	// SHA-256(0x01 || MPI(2))
+	h := sha256.Sum256([]byte{0x01, 0x00, 0x00, 0x00, 0x01, 0x02})
+	if !eq(hashElements(MODP1536, proofHash{}, 1, G1), new(big.Int).SetBytes(h[:])) {
+		t.Errorf("the default proof hash should be SHA-256 over the magic byte and MPIs")
+	}
+}
+
+func TestWithProofHash(t *testing.T) {
+	o := WithProofHash(DefaultOptions(), sha512.New, []byte("test-smp"))
+
+	alice := NewProtocol(o)
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(o)
+	bob.Secret = big.NewInt(42)
+
+	if alice.Group() != MODP1536 {
+		t.Errorf("WithProofHash should keep the group")
+	}
+
+	m, _ := alice.Compare()
+	if c := m.MPIs()[1]; c.BitLen() <= 256 {
+		t.Errorf("expected a SHA-512 challenge, got %d bits", c.BitLen())
+	}
+
+	m, _ = bob.Receive(m)
+	m, _ = alice.Receive(m)
+	m, _ = bob.Receive(m)
+	if _, ok := m.(SMP4); !ok {
+		t.Fatalf("expected SMP4, got %T", m)
+	}
+
+	if _, err := alice.Receive(m); err != nil {
+		t.Errorf("unexpected error: %s", err)
+	}
+
+	// a peer with a different prefix can't verify the proofs
+	other := NewProtocol(WithProofHash(DefaultOptions(), sha512.New, []byte("other")))
+	other.Secret = big.NewInt(42)
+	alice.Secret = big.NewInt(42)
+	m, _ = alice.Compare()
+	if m, _ = other.Receive(m); m != (SMPAbort{}) {
+		t.Errorf("expected the proofs to fail with a different prefix, got %T", m)
+	}
+}
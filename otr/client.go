@@ -1,6 +1,7 @@
 package otr
 
 import (
+	"bytes"
 	"crypto/sha256"
 	"errors"
 	"math/big"
@@ -9,9 +10,6 @@ import (
 	"github.com/twstrike/otr3"
 )
 
-//TODO: replicate the otr3.SMPEvents but with structs
-//this way, Ask* events will have the "question"; Progress event will have the percentage and we stop calling event handlers with "zeroed" parameters (question = "", percent = 0)
-
 type clientState int
 
 const (
@@ -26,40 +24,55 @@ type SecretParams interface {
 }
 
 type Client struct {
-	smp            *smp.Protocol
-	pendingMessage *smp.SMP1
-	secParams      SecretParams
+	smp       *smp.Protocol
+	secParams SecretParams
 
 	smpEventHandler otr3.SMPEventHandler
 	state           clientState
 }
 
 func NewClient(conv otr3.Conversation) *Client {
-	ops := &otr3.SmpOptions{conv}
 	sec := &otr3.SmpSecretParams{conv}
 
 	c := &Client{
-		smp:       smp.NewProtocol(ops),
+		smp:       smp.NewProtocol(smp.DefaultOptions()),
 		secParams: sec,
 		state:     notStarted,
 
 		//smpEventHandler: handler,
 	}
 
-	// go c.watchEvents()
+	c.smp.EventHandler = smp.EventHandlerFunc(c.handleEvent)
 
 	return c
 }
 
-func (c *Client) watchEvents() {
-	//TODO: should emit events to the smpHandler
-	for e := range c.smp.Events() {
-		switch e {
-		case smp.InProgress:
-			c.state = inProgress
-		default:
-			c.state = notStarted
+func (c *Client) handleEvent(e smp.EventDetail) {
+	if e.Kind() == smp.InProgress {
+		c.state = inProgress
+	} else {
+		c.state = notStarted
+	}
+
+	switch d := e.(type) {
+	case smp.AskForSecret:
+		if d.Question == "" {
+			c.emitEvent(otr3.SMPEventAskForSecret, 25, "")
+		} else {
+			c.emitEvent(otr3.SMPEventAskForAnswer, 25, d.Question)
 		}
+	case smp.Progress:
+		c.emitEvent(otr3.SMPEventInProgress, d.Percent, "")
+	case smp.Succeeded:
+		c.emitEvent(otr3.SMPEventSuccess, 100, "")
+	case smp.Failed:
+		c.emitEvent(otr3.SMPEventFailure, 100, "")
+	case smp.CheatDetected:
+		c.emitEvent(otr3.SMPEventCheated, 0, "")
+	case smp.Aborted, smp.TimedOut:
+		c.emitEvent(otr3.SMPEventAbort, 0, "")
+	case smp.ProtocolError:
+		c.emitEvent(otr3.SMPEventError, 0, "")
 	}
 }
 
@@ -88,26 +101,13 @@ func (c *Client) Start(question, secret string) (TLV, error) {
 }
 
 func (c *Client) Continue(secret string) (TLV, error) {
-	if c.smp.Secret != nil {
-		return nil, errors.New("SMP already in progress")
-	}
-
-	var m *smp.SMP1
-	m, c.pendingMessage = c.pendingMessage, nil
-	if m == nil {
+	if _, ok := c.smp.PendingQuestion(); !ok {
 		return nil, errors.New("can't continue without having received a SMP1")
 	}
 
 	// they are the initiator
-	c.smp.Secret = generateSecret(c.secParams.TheirFingerprint(),
-		c.secParams.OurFingerprint(), c.secParams.SSID(), []byte(secret))
-
-	ret, err := c.smp.Receive(m)
-	if err != nil {
-		return nil, err
-	}
-
-	return Encode(ret)
+	return encodeReply(c.smp.Respond(generateSecret(c.secParams.TheirFingerprint(),
+		c.secParams.OurFingerprint(), c.secParams.SSID(), []byte(secret))))
 }
 
 func (c *Client) Abort() (TLV, error) {
@@ -121,29 +121,56 @@ func (c *Client) Receive(tlv TLV) (TLV, error) {
 		return nil, err
 	}
 
-	if m, ok := dec.(*smp.SMP1); ok && c.smp.Secret == nil {
-		c.pendingMessage = m
-		c.emitEvent(otr3.SMPEventAskForSecret, 0, "")
-		return nil, nil
-	}
+	return encodeReply(c.smp.Receive(dec))
+}
 
-	if m, ok := dec.(*smp.SMP1Q); ok && c.smp.Secret == nil {
-		//TODO: fixme
-		c.pendingMessage = &(m.SMP1)
-		c.emitEvent(otr3.SMPEventAskForAnswer, 0, m.Question())
-		return nil, nil
+// ReceiveData processes the decrypted body of a data message: the human
+// readable message, optionally followed by a NUL byte and a TLV stream. It
+// returns the message, the TLVs to send back and the TLVs that are neither
+// SMP nor padding. A disconnected TLV aborts the SMP in progress.
+func (c *Client) ReceiveData(body []byte) (msg []byte, reply TLV, others []Record, err error) {
+	msg, tlvs := body, []byte(nil)
+	if i := bytes.IndexByte(body, 0); i != -1 {
+		msg, tlvs = body[:i], body[i+1:]
 	}
 
-	ret, err := c.smp.Receive(dec)
+	msgs, others, err := DecodeAll(tlvs)
 	if err != nil {
+		return msg, nil, nil, err
+	}
+
+	for _, m := range msgs {
+		var r TLV
+		r, err = encodeReply(c.smp.Receive(m))
+		reply = append(reply, r...)
+
+		if err != nil {
+			return msg, reply, others, err
+		}
+	}
+
+	for _, r := range others {
+		if r.Type == TLVDisconnected && c.state == inProgress {
+			c.smp.Abort()
+		}
+	}
+
+	return msg, reply, others, nil
+}
+
+// encodeReply encodes the message addressed to the peer, if any. When err is
+// not nil the protocol was aborted and m is the SMPAbort the peer must receive.
+func encodeReply(m smp.Message, err error) (TLV, error) {
+	if m == nil {
 		return nil, err
 	}
 
-	if ret == nil {
-		return nil, nil
+	tlv, encErr := Encode(m)
+	if err == nil {
+		err = encErr
 	}
 
-	return Encode(ret)
+	return tlv, err
 }
 
 //FIXME: why does the event have to be handled with a percent and a question?
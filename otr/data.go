@@ -47,27 +47,12 @@ func extractWord(d []byte) ([]byte, uint32, bool) {
 
 func extractMPI(d []byte) (newPoint []byte, mpi *big.Int, ok bool) {
 	d, mpiLen, ok := extractWord(d)
-	if !ok || len(d) < int(mpiLen) {
+	if !ok || uint64(len(d)) < uint64(mpiLen) {
 		return nil, nil, false
 	}
 
-	mpi = new(big.Int).SetBytes(d[:int(mpiLen)])
-	newPoint = d[int(mpiLen):]
+	mpi = new(big.Int).SetBytes(d[:mpiLen])
+	newPoint = d[mpiLen:]
 	ok = true
 	return
 }
-
-func extractMPIs(d []byte) ([]byte, []*big.Int, bool) {
-	current, mpiCount, ok := extractWord(d)
-	if !ok {
-		return nil, nil, false
-	}
-	result := make([]*big.Int, int(mpiCount))
-	for i := 0; i < int(mpiCount); i++ {
-		current, result[i], ok = extractMPI(current)
-		if !ok {
-			return nil, nil, false
-		}
-	}
-	return current, result, true
-}
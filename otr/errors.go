@@ -0,0 +1,44 @@
+package otr
+
+import (
+	"errors"
+	"strconv"
+)
+
+var (
+	// ErrTruncated means the data ends in the middle of a TLV
+	ErrTruncated = errors.New("otr: truncated TLV")
+
+	// ErrUnsupportedMessage means Encode got a message it can't encode
+	ErrUnsupportedMessage = errors.New("otr: unsupported SMP message")
+
+	// ErrValueTooLong means a TLV value does not fit in 65535 bytes
+	ErrValueTooLong = errors.New("otr: TLV value too long")
+
+	// ErrQuestionHasNUL means the question of an SMP1Q contains a NUL byte,
+	// which terminates the question in its TLV
+	ErrQuestionHasNUL = errors.New("otr: question contains a NUL byte")
+)
+
+// UnknownTypeError means a TLV is not of an SMP type
+type UnknownTypeError struct {
+	Type uint16
+}
+
+func (e *UnknownTypeError) Error() string {
+	return "otr: TLV type " + strconv.Itoa(int(e.Type)) + " is not an SMP message"
+}
+
+// MalformedTLVError means the value of an SMP TLV is invalid
+type MalformedTLVError struct {
+	Type   uint16
+	Reason string
+}
+
+func (e *MalformedTLVError) Error() string {
+	return "otr: malformed TLV of type " + strconv.Itoa(int(e.Type)) + ": " + e.Reason
+}
+
+func malformed(t uint16, reason string) error {
+	return &MalformedTLVError{Type: t, Reason: reason}
+}
@@ -0,0 +1,80 @@
+package otr
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+// seedTLVs are valid TLVs of every SMP type, and a few invalid ones
+func seedTLVs(f *testing.F) [][]byte {
+	var seeds [][]byte
+
+	// a short TLV of every type, including unknown ones
+	for tType := uint16(0); tType <= 9; tType++ {
+		seeds = append(seeds, generateTLV(tType, tlvValue(nil)))
+	}
+
+	for _, m := range smpMessages(f) {
+		tlv, err := Encode(m)
+		if err != nil {
+			f.Fatal(err)
+		}
+
+		seeds = append(seeds, tlv)
+	}
+
+	return append(seeds,
+		[]byte{},
+		[]byte{0x00, 0x07, 0x00, 0x02, '?', 0x00},
+		[]byte{0x00, 0x02, 0x00, 0x04, 0xff, 0xff, 0xff, 0xff},
+		[]byte{0x00, 0x05, 0x00, 0x08, 0x00, 0x00, 0x00, 0x03, 0xff, 0xff, 0xff, 0xff},
+		[]byte{0x00, 0x06, 0x00, 0x00},
+	)
+}
+
+func FuzzDecode(f *testing.F) {
+	for _, seed := range seedTLVs(f) {
+		f.Add(seed)
+	}
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		m, err := Decode(data)
+		if err != nil {
+			return
+		}
+
+		tlv, err := Encode(m)
+		if err != nil {
+			t.Fatalf("can't encode a decoded message: %v", err)
+		}
+
+		again, err := Decode(tlv)
+		if err != nil {
+			t.Fatalf("can't decode an encoded message: %v", err)
+		}
+
+		if !reflect.DeepEqual(deref(again), deref(m)) {
+			t.Fatalf("round trip changed %#v into %#v", m, again)
+		}
+	})
+}
+
+func FuzzDecodeAll(f *testing.F) {
+	seeds := seedTLVs(f)
+	for _, seed := range seeds {
+		f.Add(seed)
+	}
+	f.Add(bytes.Join(seeds, nil))
+
+	f.Fuzz(func(t *testing.T, data []byte) {
+		msgs, others, err := DecodeAll(data)
+		if err != nil {
+			return
+		}
+
+		if _, err := EncodeAll(msgs, others); err != nil {
+			t.Fatalf("can't encode a decoded stream: %v", err)
+		}
+	})
+}
@@ -2,12 +2,23 @@ package otr
 
 import (
 	"bytes"
-	"errors"
 	"math/big"
+	"strconv"
 
 	"github.com/juniorz/smp"
 )
 
+// Types of the TLVs of an OTRv3 data message that are not SMP messages
+const (
+	// TLVPadding carries random bytes to hide the length of the message
+	TLVPadding = uint16(0x00)
+	// TLVDisconnected means the peer ended the private conversation
+	TLVDisconnected = uint16(0x01)
+	// TLVExtraSymmetricKey asks to use the extra symmetric key. Its value
+	// starts with a 4-byte use, followed by use-specific data.
+	TLVExtraSymmetricKey = uint16(0x08)
+)
+
 const (
 	tlvTypeSMP1     = uint16(0x02)
 	tlvTypeSMP2     = uint16(0x03)
@@ -19,31 +30,111 @@ const (
 
 type TLV []byte
 
+// Record is a TLV of a data message that is not an SMP message
+type Record struct {
+	Type  uint16
+	Value []byte
+}
+
+// tlvMPIs is the number of MPIs of each SMP TLV type
+var tlvMPIs = map[uint16]int{
+	tlvTypeSMP1:     6,
+	tlvTypeSMP1Q:    6,
+	tlvTypeSMP2:     11,
+	tlvTypeSMP3:     8,
+	tlvTypeSMP4:     3,
+	tlvTypeSMPAbort: 0,
+}
+
+// maxMPILen is the length in bytes of the largest MPI of SMP over the
+// 1536-bit MODP group, an element modulo p
+const maxMPILen = 192
+
+// Encode returns the TLV of an SMP message. It returns an error, and never
+// panics, on messages it can't encode.
 func Encode(m smp.Message) (TLV, error) {
-	var tlv TLV
+	var tType uint16
+	var question []byte
 
+	m = deref(m)
 	switch v := m.(type) {
 	case smp.SMP1:
-		tlv = generateTLV(tlvTypeSMP1, tlvValue(m.MPIs()))
+		tType = tlvTypeSMP1
 	case smp.SMP1Q:
-		data := append(
-			append([]byte(v.Question()), 0),
-			tlvValue(m.MPIs())...,
-		)
-		tlv = generateTLV(tlvTypeSMP1Q, data)
+		tType, question = tlvTypeSMP1Q, []byte(v.Question())
 	case smp.SMP2:
-		tlv = generateTLV(tlvTypeSMP2, tlvValue(m.MPIs()))
+		tType = tlvTypeSMP2
 	case smp.SMP3:
-		tlv = generateTLV(tlvTypeSMP3, tlvValue(m.MPIs()))
+		tType = tlvTypeSMP3
 	case smp.SMP4:
-		tlv = generateTLV(tlvTypeSMP4, tlvValue(m.MPIs()))
+		tType = tlvTypeSMP4
 	case smp.SMPAbort:
-		tlv = generateTLV(tlvTypeSMPAbort, tlvValue(m.MPIs()))
+		tType = tlvTypeSMPAbort
+	default:
+		return nil, ErrUnsupportedMessage
+	}
+
+	mpis := m.MPIs()
+	if len(mpis) != tlvMPIs[tType] {
+		return nil, ErrUnsupportedMessage
+	}
+
+	var value []byte
+	if tType == tlvTypeSMP1Q {
+		if bytes.IndexByte(question, 0) != -1 {
+			return nil, ErrQuestionHasNUL
+		}
+
+		value = append(question, 0)
+	}
+
+	for _, mpi := range mpis {
+		if mpi == nil || mpi.Sign() < 0 {
+			return nil, ErrUnsupportedMessage
+		}
+	}
+	value = append(value, tlvValue(mpis)...)
+
+	if len(value) > 0xffff {
+		return nil, ErrValueTooLong
+	}
+
+	return generateTLV(tType, value), nil
+}
+
+// deref returns the message a pointer to a message points to, like the ones
+// returned by Decode, or nil for a nil pointer
+func deref(m smp.Message) smp.Message {
+	switch v := m.(type) {
+	case *smp.SMP1:
+		if v != nil {
+			return *v
+		}
+	case *smp.SMP1Q:
+		if v != nil {
+			return *v
+		}
+	case *smp.SMP2:
+		if v != nil {
+			return *v
+		}
+	case *smp.SMP3:
+		if v != nil {
+			return *v
+		}
+	case *smp.SMP4:
+		if v != nil {
+			return *v
+		}
+	case *smp.SMPAbort:
+		if v != nil {
+			return *v
+		}
 	default:
-		panic("unreachable")
+		return m
 	}
 
-	return tlv, nil
+	return nil
 }
 
 func tlvValue(mpis []*big.Int) []byte {
@@ -61,41 +152,147 @@ func generateTLV(tp uint16, value []byte) TLV {
 	return append(data, value...)
 }
 
+// Decode reads the SMP message of the TLV m, which must hold exactly one TLV.
+// See DecodeAll for a stream of TLVs. It returns an error, and never panics,
+// on invalid input.
 func Decode(m TLV) (smp.Message, error) {
-	tBytes, tType, ok := extractShort(m)
-	if !ok {
-		return nil, errors.New("wrong tlv type")
+	tType, value, rest, err := extractTLV(m)
+	if err != nil {
+		return nil, err
+	}
+
+	if !isSMPType(tType) {
+		return nil, &UnknownTypeError{Type: tType}
 	}
 
-	var tLen uint16
-	tBytes, tLen, ok = extractShort(tBytes)
+	if len(rest) != 0 {
+		return nil, malformed(tType, "trailing data after the TLV")
+	}
+
+	return parseTLV(tType, value)
+}
+
+// DecodeAll reads every TLV of the TLV stream of a data message. It returns
+// the SMP messages and the other TLVs, in order, and skips padding.
+func DecodeAll(data []byte) ([]smp.Message, []Record, error) {
+	var msgs []smp.Message
+	var others []Record
+
+	for len(data) > 0 {
+		tType, value, rest, err := extractTLV(data)
+		if err != nil {
+			return nil, nil, err
+		}
+		data = rest
+
+		switch {
+		case tType == TLVPadding:
+		case isSMPType(tType):
+			m, err := parseTLV(tType, value)
+			if err != nil {
+				return nil, nil, err
+			}
+
+			msgs = append(msgs, m)
+		default:
+			others = append(others, Record{Type: tType, Value: value})
+		}
+	}
+
+	return msgs, others, nil
+}
+
+// EncodeAll returns the TLV stream of the SMP messages followed by the other
+// records
+func EncodeAll(msgs []smp.Message, others []Record) (TLV, error) {
+	var data TLV
+	for _, m := range msgs {
+		tlv, err := Encode(m)
+		if err != nil {
+			return nil, err
+		}
+
+		data = append(data, tlv...)
+	}
+
+	for _, r := range others {
+		if len(r.Value) > 0xffff {
+			return nil, ErrValueTooLong
+		}
+
+		data = append(data, generateTLV(r.Type, r.Value)...)
+	}
+
+	return data, nil
+}
+
+// extractTLV returns the type and value of the first TLV of d, and the data
+// that follows it
+func extractTLV(d []byte) (uint16, []byte, []byte, error) {
+	d, tType, ok := extractShort(d)
 	if !ok {
-		return nil, errors.New("wrong tlv length")
+		return 0, nil, nil, ErrTruncated
 	}
 
-	if len(tBytes) < int(tLen) {
-		return nil, errors.New("wrong tlv value")
+	d, tLen, ok := extractShort(d)
+	if !ok || len(d) < int(tLen) {
+		return 0, nil, nil, ErrTruncated
 	}
 
-	return parseTLV(tType, tLen, tBytes)
+	return tType, d[:tLen], d[tLen:], nil
 }
 
-func parseTLV(t uint16, l uint16, v []byte) (smp.Message, error) {
+func isSMPType(t uint16) bool {
+	_, ok := tlvMPIs[t]
+	return ok
+}
+
+// parseTLV returns the SMP message of type t with the value v. It checks the
+// number of MPIs before allocating them, and bounds their size.
+func parseTLV(t uint16, v []byte) (smp.Message, error) {
+	expected, ok := tlvMPIs[t]
+	if !ok {
+		return nil, &UnknownTypeError{Type: t}
+	}
+
+	// libotr sends aborts without a value
+	if t == tlvTypeSMPAbort && len(v) == 0 {
+		return smp.SMPAbort{}, nil
+	}
+
 	var question string
 	if t == tlvTypeSMP1Q {
 		nulPos := bytes.IndexByte(v, 0)
 		if nulPos == -1 {
-			return nil, errors.New("wrong tlv value")
+			return nil, malformed(t, "unterminated question")
 		}
 
-		//TODO: should we strdup?
 		question = string(v[:nulPos])
-		v = v[(nulPos + 1):]
+		v = v[nulPos+1:]
 	}
 
-	_, mpis, ok := extractMPIs(v[:int(l)])
+	v, count, ok := extractWord(v)
 	if !ok {
-		return nil, errors.New("not enough TLVs")
+		return nil, malformed(t, "missing MPI count")
+	}
+
+	if count != uint32(expected) {
+		return nil, malformed(t, "expected "+strconv.Itoa(expected)+" MPIs, got "+strconv.FormatUint(uint64(count), 10))
+	}
+
+	mpis := make([]*big.Int, expected)
+	for i := range mpis {
+		if v, mpis[i], ok = extractMPI(v); !ok {
+			return nil, malformed(t, "truncated MPI")
+		}
+
+		if len(mpis[i].Bytes()) > maxMPILen {
+			return nil, malformed(t, "MPI longer than "+strconv.Itoa(maxMPILen)+" bytes")
+		}
+	}
+
+	if len(v) != 0 {
+		return nil, malformed(t, "trailing data after the MPIs")
 	}
 
 	switch t {
@@ -109,9 +306,7 @@ func parseTLV(t uint16, l uint16, v []byte) (smp.Message, error) {
 		return smp.NewSMP3(mpis...)
 	case tlvTypeSMP4:
 		return smp.NewSMP4(mpis...)
-	case tlvTypeSMPAbort:
-		return smp.NewSMPAbort(mpis...)
-	default:
-		panic("unreachable")
 	}
+
+	return smp.SMPAbort{}, nil
 }
@@ -0,0 +1,226 @@
+package otr
+
+import (
+	"bytes"
+	"math/big"
+	"reflect"
+	"testing"
+
+	"github.com/juniorz/smp"
+)
+
+// smpMessages returns the messages of a run of the protocol with matching
+// secrets, started with a question, followed by an SMPAbort
+func smpMessages(tb testing.TB) []smp.Message {
+	alice := smp.NewProtocol(smp.DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	alice.Question = "what is the answer?"
+	bob := smp.NewProtocol(smp.DefaultOptions())
+
+	m1, err := alice.Compare()
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	if _, err := bob.Receive(m1); err != nil {
+		tb.Fatal(err)
+	}
+
+	m2, err := bob.Respond(big.NewInt(42))
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	m3, err := alice.Receive(m2)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	m4, err := bob.Receive(m3)
+	if err != nil {
+		tb.Fatal(err)
+	}
+
+	return []smp.Message{m1, m2, m3, m4, smp.SMPAbort{}}
+}
+
+func TestDecodeAllReadsEveryTLV(t *testing.T) {
+	msgs := smpMessages(t)
+	others := []Record{
+		{Type: TLVDisconnected, Value: []byte{}},
+		{Type: TLVExtraSymmetricKey, Value: []byte{0, 0, 0, 1, 'x'}},
+	}
+
+	data, err := EncodeAll(msgs, others)
+	if err != nil {
+		t.Fatal(err)
+	}
+	data = append(data, generateTLV(TLVPadding, make([]byte, 10))...)
+
+	gotMsgs, gotOthers, err := DecodeAll(data)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(gotMsgs) != len(msgs) {
+		t.Fatalf("expected %d SMP messages, got %d", len(msgs), len(gotMsgs))
+	}
+
+	q, ok := gotMsgs[0].(*smp.SMP1Q)
+	if !ok || q.Question() != "what is the answer?" || !reflect.DeepEqual(q.MPIs(), msgs[0].MPIs()) {
+		t.Errorf("unexpected SMP1Q %#v", gotMsgs[0])
+	}
+
+	if _, ok := gotMsgs[len(msgs)-1].(smp.SMPAbort); !ok {
+		t.Errorf("expected SMPAbort, got %#v", gotMsgs[len(msgs)-1])
+	}
+
+	if !reflect.DeepEqual(gotOthers, others) {
+		t.Errorf("unexpected records %#v", gotOthers)
+	}
+}
+
+func TestDecodeAllRejectsTruncatedStreams(t *testing.T) {
+	data, _ := EncodeAll(nil, []Record{{Type: TLVPadding, Value: make([]byte, 4)}})
+
+	for i := 1; i < len(data); i++ {
+		if _, _, err := DecodeAll(data[:i]); err == nil {
+			t.Errorf("a stream truncated to %d bytes should be rejected", i)
+		}
+	}
+
+	if msgs, others, err := DecodeAll(nil); err != nil || msgs != nil || others != nil {
+		t.Errorf("an empty stream should have no TLVs")
+	}
+}
+
+func TestClientReceivesDataMessageBodies(t *testing.T) {
+	c := &Client{smp: smp.NewProtocol(smp.DefaultOptions())}
+	c.smp.EventHandler = smp.EventHandlerFunc(c.handleEvent)
+
+	tlvs, _ := EncodeAll(smpMessages(t)[:1], []Record{{Type: TLVPadding, Value: make([]byte, 7)}})
+	body := append([]byte("hello\x00"), tlvs...)
+
+	msg, reply, others, err := c.ReceiveData(body)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !bytes.Equal(msg, []byte("hello")) || reply != nil || others != nil {
+		t.Errorf("unexpected result %q %x %v", msg, reply, others)
+	}
+
+	if q, ok := c.smp.PendingQuestion(); !ok || q != "what is the answer?" {
+		t.Errorf("the SMP1Q should wait for a secret")
+	}
+
+	disconnected, _ := EncodeAll(nil, []Record{{Type: TLVDisconnected}})
+	_, _, others, err = c.ReceiveData(append([]byte("bye\x00"), disconnected...))
+	if err != nil || len(others) != 1 || others[0].Type != TLVDisconnected {
+		t.Fatalf("unexpected result %v %v", others, err)
+	}
+
+	if _, ok := c.smp.PendingQuestion(); ok {
+		t.Errorf("disconnecting should abort the SMP")
+	}
+
+	if msg, _, _, _ := c.ReceiveData([]byte("no tlvs")); string(msg) != "no tlvs" {
+		t.Errorf("unexpected message %q", msg)
+	}
+}
+
+func TestDecodeReturnsTypedErrors(t *testing.T) {
+	smp4, _ := Encode(smp.SMP4{})
+	if smp4 != nil {
+		t.Errorf("a message without values should not be encoded")
+	}
+
+	valid, _ := Encode(smpMessages(t)[0])
+
+	cases := map[string]struct {
+		data []byte
+		err  interface{}
+	}{
+		"empty":          {[]byte{}, ErrTruncated},
+		"short header":   {[]byte{0x00, 0x02, 0x00}, ErrTruncated},
+		"short value":    {[]byte{0x00, 0x02, 0x00, 0x05, 0x00}, ErrTruncated},
+		"padding":        {generateTLV(TLVPadding, nil), &UnknownTypeError{}},
+		"unknown type":   {generateTLV(0x42, tlvValue(nil)), &UnknownTypeError{}},
+		"no count":       {generateTLV(tlvTypeSMP2, nil), &MalformedTLVError{}},
+		"huge count":     {generateTLV(tlvTypeSMP2, []byte{0xff, 0xff, 0xff, 0xff}), &MalformedTLVError{}},
+		"wrong count":    {generateTLV(tlvTypeSMP4, tlvValue([]*big.Int{big.NewInt(1)})), &MalformedTLVError{}},
+		"abort with MPI": {generateTLV(tlvTypeSMPAbort, tlvValue([]*big.Int{big.NewInt(1)})), &MalformedTLVError{}},
+		"huge MPI":       {generateTLV(tlvTypeSMP4, []byte{0, 0, 0, 3, 0xff, 0xff, 0xff, 0xff}), &MalformedTLVError{}},
+		"long MPI": {generateTLV(tlvTypeSMP4, tlvValue([]*big.Int{
+			new(big.Int).Lsh(big.NewInt(1), maxMPILen*8), big.NewInt(1), big.NewInt(1),
+		})), &MalformedTLVError{}},
+		"no question end": {generateTLV(tlvTypeSMP1Q, []byte("what?")), &MalformedTLVError{}},
+		"trailing MPIs":   {generateTLV(tlvTypeSMP4, append(tlvValue([]*big.Int{big.NewInt(1), big.NewInt(1), big.NewInt(1)}), 0)), &MalformedTLVError{}},
+		"trailing TLV":    {append(append([]byte{}, valid...), 0), &MalformedTLVError{}},
+	}
+
+	for name, c := range cases {
+		_, err := Decode(c.data)
+
+		switch expected := c.err.(type) {
+		case *UnknownTypeError:
+			if _, ok := err.(*UnknownTypeError); !ok {
+				t.Errorf("%s: expected an UnknownTypeError, got %v", name, err)
+			}
+		case *MalformedTLVError:
+			if _, ok := err.(*MalformedTLVError); !ok {
+				t.Errorf("%s: expected a MalformedTLVError, got %v", name, err)
+			}
+		case error:
+			if err != expected {
+				t.Errorf("%s: expected %v, got %v", name, expected, err)
+			}
+		}
+	}
+}
+
+func TestSMP1QIsSlicedAfterTheQuestion(t *testing.T) {
+	// the question takes more bytes than the MPIs that follow it
+	q, _ := smp.NewSMP1Q(string(bytes.Repeat([]byte{'?'}, 500)),
+		big.NewInt(1), big.NewInt(2), big.NewInt(3), big.NewInt(4), big.NewInt(5), big.NewInt(6))
+
+	tlv, err := Encode(q)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, err := Decode(tlv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if d, ok := m.(*smp.SMP1Q); !ok || d.Question() != q.Question() || !reflect.DeepEqual(d.MPIs(), q.MPIs()) {
+		t.Errorf("unexpected message %#v", m)
+	}
+}
+
+func TestEncodeDoesNotPanic(t *testing.T) {
+	var nilSMP1 *smp.SMP1
+	q, _ := smp.NewSMP1Q("a\x00b", big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1))
+	long, _ := smp.NewSMP1Q(string(make([]byte, 0x10000)), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1))
+
+	for _, c := range []struct {
+		m   smp.Message
+		err error
+	}{
+		{nil, ErrUnsupportedMessage},
+		{nilSMP1, ErrUnsupportedMessage},
+		{smp.SMP2{}, ErrUnsupportedMessage},
+		{q, ErrQuestionHasNUL},
+		{long, ErrQuestionHasNUL},
+	} {
+		if _, err := Encode(c.m); err != c.err {
+			t.Errorf("Encode(%#v): expected %v, got %v", c.m, c.err, err)
+		}
+	}
+
+	// libotr sends aborts without a value
+	if m, err := Decode(generateTLV(tlvTypeSMPAbort, nil)); err != nil || m != (smp.SMPAbort{}) {
+		t.Errorf("an empty abort should decode, got %v", err)
+	}
+}
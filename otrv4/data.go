@@ -0,0 +1,80 @@
+package otrv4
+
+import "math/big"
+
+func appendShort(l []byte, r uint16) []byte {
+	return append(l, byte(r>>8), byte(r))
+}
+
+func appendWord(l []byte, r uint32) []byte {
+	return append(l, byte(r>>24), byte(r>>16), byte(r>>8), byte(r))
+}
+
+func appendData(l, r []byte) []byte {
+	return append(appendWord(l, uint32(len(r))), r...)
+}
+
+func appendPoint(l []byte, r *big.Int) []byte {
+	return append(l, Ed448.Encode(r)...)
+}
+
+// appendScalar appends r as a little-endian SCALAR
+func appendScalar(l []byte, r *big.Int) []byte {
+	b := r.FillBytes(make([]byte, scalarSize))
+	reverse(b)
+	return append(l, b...)
+}
+
+func extractShort(d []byte) ([]byte, uint16, bool) {
+	if len(d) < 2 {
+		return nil, 0, false
+	}
+
+	return d[2:], uint16(d[0])<<8 |
+		uint16(d[1]), true
+}
+
+func extractWord(d []byte) ([]byte, uint32, bool) {
+	if len(d) < 4 {
+		return nil, 0, false
+	}
+
+	return d[4:], uint32(d[0])<<24 |
+		uint32(d[1])<<16 |
+		uint32(d[2])<<8 |
+		uint32(d[3]), true
+}
+
+func extractData(d []byte) ([]byte, []byte, bool) {
+	d, l, ok := extractWord(d)
+	if !ok || uint32(len(d)) < l {
+		return nil, nil, false
+	}
+
+	return d[l:], d[:l], true
+}
+
+func extractPoint(d []byte) ([]byte, *big.Int, bool) {
+	if len(d) < pointSize {
+		return nil, nil, false
+	}
+
+	return d[pointSize:], new(big.Int).SetBytes(d[:pointSize]), true
+}
+
+// extractScalar reads a little-endian SCALAR, which must be reduced modulo q
+func extractScalar(d []byte) ([]byte, *big.Int, bool) {
+	if len(d) < scalarSize {
+		return nil, nil, false
+	}
+
+	b := append([]byte(nil), d[:scalarSize]...)
+	reverse(b)
+
+	s := new(big.Int).SetBytes(b)
+	if s.Cmp(q) >= 0 {
+		return nil, nil, false
+	}
+
+	return d[scalarSize:], s, true
+}
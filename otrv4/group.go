@@ -0,0 +1,211 @@
+package otrv4
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/cloudflare/circl/ecc/goldilocks"
+	"github.com/juniorz/smp"
+)
+
+const (
+	pointSize  = 57
+	scalarSize = goldilocks.ScalarSize
+)
+
+var (
+	curve goldilocks.Curve
+
+	// q is the order of the Ed448 prime order subgroup
+	q *big.Int
+	// g is the encoding of the Ed448 base point
+	g *big.Int
+
+	// Ed448 is the Ed448-Goldilocks group used by OTRv4 SMP.
+	// Its elements are the 57-byte point encodings defined in RFC8032.
+	Ed448 smp.Group = ed448{}
+
+	errInvalidPoint = errors.New("invalid point")
+)
+
+func init() {
+	order := curve.Order()
+	q = scalarToInt(&order)
+	g = pointToInt(curve.Generator())
+}
+
+type ed448 struct{}
+
+func (ed448) Generator() *big.Int {
+	return g
+}
+
+func (ed448) Order() *big.Int {
+	return q
+}
+
+func (ed448) Identity() *big.Int {
+	return pointToInt(curve.Identity())
+}
+
+func (ed448) Exp(b, x *big.Int) *big.Int {
+	return pointToInt(pointOf(b, x))
+}
+
+// MultiExp implements smp.MultiExponentiator. The term with the base point,
+// if any, is computed together with another term by a variable time double
+// scalar multiplication.
+func (ed448) MultiExp(bases, exps []*big.Int) *big.Int {
+	acc := curve.Identity()
+
+	done := make([]bool, len(bases))
+	for i, b := range bases {
+		if !eq(b, g) {
+			continue
+		}
+
+		for j := range bases {
+			if j != i {
+				acc = curve.CombinedMult(intToScalar(exps[i]), intToScalar(exps[j]), mustPoint(bases[j]))
+				done[i], done[j] = true, true
+				break
+			}
+		}
+
+		break
+	}
+
+	for i, b := range bases {
+		if !done[i] {
+			acc = curve.Add(acc, pointOf(b, exps[i]))
+		}
+	}
+
+	return pointToInt(acc)
+}
+
+// pointOf returns b^x as a point
+func pointOf(b, x *big.Int) *goldilocks.Point {
+	if eq(b, g) {
+		return curve.ScalarBaseMult(intToScalar(x))
+	}
+
+	return curve.ScalarMult(intToScalar(x), mustPoint(b))
+}
+
+func (ed448) Mul(l, r *big.Int) *big.Int {
+	return pointToInt(curve.Add(mustPoint(l), mustPoint(r)))
+}
+
+func (ed448) Inverse(b *big.Int) *big.Int {
+	p := mustPoint(b)
+	p.Neg()
+	return pointToInt(p)
+}
+
+// IsElement checks b encodes a point on the curve, other than the identity,
+// that belongs to the prime order subgroup
+func (ed448) IsElement(b *big.Int) bool {
+	p, err := intToPoint(b)
+	if err != nil || p.IsIdentity() {
+		return false
+	}
+
+	return mulByOrder(p).IsIdentity()
+}
+
+func (ed448) Encode(b *big.Int) []byte {
+	return b.FillBytes(make([]byte, pointSize))
+}
+
+func (ed448) Decode(b []byte) (*big.Int, error) {
+	if len(b) != pointSize {
+		return nil, errInvalidPoint
+	}
+
+	x := new(big.Int).SetBytes(b)
+	if !Ed448.IsElement(x) {
+		return nil, errInvalidPoint
+	}
+
+	return x, nil
+}
+
+// HashToScalar implements smp.ProofHasher as HashToScalar(ix || elements)
+func (ed448) HashToScalar(ix byte, elements ...*big.Int) *big.Int {
+	values := make([][]byte, len(elements))
+	for i, e := range elements {
+		values[i] = Ed448.Encode(e)
+	}
+
+	return hashToScalar(ix, values...)
+}
+
+// mulByOrder computes q*p without reducing q, using double-and-add.
+// It is not constant time, and should only be used with public points.
+func mulByOrder(p *goldilocks.Point) *goldilocks.Point {
+	r := curve.Identity()
+	for i := q.BitLen() - 1; i >= 0; i-- {
+		r.Double()
+		if q.Bit(i) == 1 {
+			r.Add(p)
+		}
+	}
+
+	return r
+}
+
+func pointToInt(p *goldilocks.Point) *big.Int {
+	b, _ := p.MarshalBinary()
+	return new(big.Int).SetBytes(b)
+}
+
+func intToPoint(x *big.Int) (*goldilocks.Point, error) {
+	if x.Sign() < 0 || x.BitLen() > pointSize*8 {
+		return nil, errInvalidPoint
+	}
+
+	b := x.FillBytes(make([]byte, pointSize))
+	// the last byte only carries the sign of x
+	if b[pointSize-1]&0x7f != 0 {
+		return nil, errInvalidPoint
+	}
+
+	return goldilocks.FromBytes(b)
+}
+
+// mustPoint decodes a point that has already been validated
+func mustPoint(x *big.Int) *goldilocks.Point {
+	p, err := intToPoint(x)
+	if err != nil {
+		panic(err)
+	}
+
+	return p
+}
+
+func intToScalar(x *big.Int) *goldilocks.Scalar {
+	r := new(big.Int).Mod(x, q)
+	b := r.FillBytes(make([]byte, scalarSize))
+	reverse(b)
+
+	k := &goldilocks.Scalar{}
+	k.FromBytes(b)
+	return k
+}
+
+func scalarToInt(k *goldilocks.Scalar) *big.Int {
+	b := append([]byte(nil), k[:]...)
+	reverse(b)
+	return new(big.Int).SetBytes(b)
+}
+
+func reverse(b []byte) {
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+}
+
+func eq(l, r *big.Int) bool {
+	return l.Cmp(r) == 0
+}
@@ -0,0 +1,58 @@
+package otrv4
+
+import (
+	"math/big"
+
+	"github.com/juniorz/smp"
+	"golang.org/x/crypto/sha3"
+)
+
+const (
+	usageFingerprint = 0x00
+	usageSMPSecret   = 0x1B
+
+	fingerprintSize = 56
+)
+
+// kdf implements KDF(usageID || values, size) = SHAKE-256("OTRv4" || usageID || values, size)
+func kdf(usageID byte, size int, values ...[]byte) []byte {
+	h := sha3.NewShake256()
+	h.Write([]byte("OTRv4"))
+	h.Write([]byte{usageID})
+	for _, v := range values {
+		h.Write(v)
+	}
+
+	out := make([]byte, size)
+	h.Read(out)
+	return out
+}
+
+// hashToScalar interprets KDF(usageID || values, 64) as a little-endian
+// integer and reduces it modulo q
+func hashToScalar(usageID byte, values ...[]byte) *big.Int {
+	h := kdf(usageID, 64, values...)
+	reverse(h)
+
+	r := new(big.Int).SetBytes(h)
+	return r.Mod(r, q)
+}
+
+// Fingerprint returns the fingerprint of a client profile, computed from its
+// long-term public key and its forging public key (both encoded as POINTs)
+func Fingerprint(longTermKey, forgingKey []byte) []byte {
+	return kdf(usageFingerprint, fingerprintSize, longTermKey, forgingKey)
+}
+
+// GenerateSecret returns the SMP secret derived from the fingerprints of the
+// initiator and the responder client profiles, the session SSID and the
+// user-specified secret
+func GenerateSecret(initiatorFingerprint, responderFingerprint, ssid, secret []byte) *big.Int {
+	return hashToScalar(usageSMPSecret,
+		[]byte{smp.Version},
+		initiatorFingerprint,
+		responderFingerprint,
+		ssid,
+		secret,
+	)
+}
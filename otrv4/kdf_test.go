@@ -0,0 +1,75 @@
+package otrv4
+
+import (
+	"bytes"
+	"encoding/hex"
+	"math/big"
+	"testing"
+
+	"github.com/juniorz/smp"
+)
+
+// The expected values below follow the definitions of the OTRv4 spec:
+//
+//	KDF(usageID || values, size) = SHAKE-256("OTRv4" || usageID || values, size)
+//	HashToScalar(d) = KDF(d, 64) as a little-endian integer, mod q
+//	fingerprint = KDF(usage_fingerprint || H || F, 56)
+//	x = HashToScalar(usage_SMP_secret || 0x01 || initiator fingerprint ||
+//	    responder fingerprint || SSID || secret)
+//
+// and were computed with an independent SHAKE-256 implementation.
+
+func fromHex(s string) []byte {
+	b, err := hex.DecodeString(s)
+	if err != nil {
+		panic(err)
+	}
+
+	return b
+}
+
+func TestFingerprintKnownAnswer(t *testing.T) {
+	fp := Fingerprint(bytes.Repeat([]byte{0xA1}, pointSize), bytes.Repeat([]byte{0xA2}, pointSize))
+	expected := fromHex("5755b320aef058ef3d3b5a5b623fa6d70f9fd89e076068964af81522b5be1c50" +
+		"0fc241060cac850736ac260f9ac389af978efe0f29181db7")
+
+	if !bytes.Equal(fp, expected) {
+		t.Errorf("got fingerprint %x", fp)
+	}
+}
+
+func TestGenerateSecretKnownAnswer(t *testing.T) {
+	initiator := Fingerprint(bytes.Repeat([]byte{0xA1}, pointSize), bytes.Repeat([]byte{0xA2}, pointSize))
+	responder := Fingerprint(bytes.Repeat([]byte{0xB1}, pointSize), bytes.Repeat([]byte{0xB2}, pointSize))
+	ssid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	expected, _ := new(big.Int).SetString("3fd2793b81305668d7dff05880a17dcd95e063039c8aff36"+
+		"517a841336176c6df0e7f826536f1f2b297ca7a184fcb1a6372f7c657253aa96", 16)
+
+	if x := GenerateSecret(initiator, responder, ssid, []byte("in the park")); !eq(x, expected) {
+		t.Errorf("got secret %x", x)
+	}
+
+	// the order of the fingerprints matters
+	if x := GenerateSecret(responder, initiator, ssid, []byte("in the park")); eq(x, expected) {
+		t.Errorf("swapped fingerprints should give another secret")
+	}
+}
+
+func TestHashToScalarKnownAnswer(t *testing.T) {
+	// the RFC 8032 encoding of the Ed448 base point
+	base := fromHex("14fa30f25b790898adc8d74e2c13bdfdc4397ce61cffd33ad7c2a0051e9c7887" +
+		"4098a36c7373ea4b62c7c9563720768824bcb66e71463f6900")
+
+	if !bytes.Equal(Ed448.Encode(Ed448.Generator()), base) {
+		t.Fatalf("unexpected base point encoding %x", Ed448.Encode(Ed448.Generator()))
+	}
+
+	// c2 = HashToScalar(0x01 || G*r2), with r2 = 1
+	expected, _ := new(big.Int).SetString("1effe473e4641450630e1e05ae81af8d05f96d87973392d4"+
+		"232de401c0f9dce196f69eac02461b767a4bda47ab985a314289a05265786ff5", 16)
+
+	if c := Ed448.(smp.ProofHasher).HashToScalar(1, Ed448.Generator()); !eq(c, expected) {
+		t.Errorf("got %x", c)
+	}
+}
@@ -0,0 +1,37 @@
+// Package otrv4 implements the Socialist Millionaires' Protocol as defined by
+// OTRv4: over Ed448-Goldilocks, with SHAKE-256 hashing and usage ID domain
+// separation. It reuses the smp state machine.
+package otrv4
+
+import (
+	"math/big"
+
+	"github.com/juniorz/smp"
+)
+
+type options struct{}
+
+// ParameterLength is the length of a SCALAR.
+//
+// Deprecated: ParameterLength is ignored by smp.Protocol.
+func (options) ParameterLength() int {
+	return scalarSize
+}
+
+func (options) IsGroupElement(g *big.Int) bool {
+	return Ed448.IsElement(g)
+}
+
+func (options) Group() smp.Group {
+	return Ed448
+}
+
+// Options returns the smp.Options for Ed448
+func Options() smp.Options {
+	return options{}
+}
+
+// NewProtocol returns an SMP protocol over Ed448
+func NewProtocol() *smp.Protocol {
+	return smp.NewProtocol(Options())
+}
@@ -0,0 +1,196 @@
+package otrv4
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+
+	"github.com/juniorz/smp"
+)
+
+func TestEd448Group(t *testing.T) {
+	g := Ed448.Generator()
+
+	if !Ed448.IsElement(g) {
+		t.Errorf("generator is not a group element")
+	}
+
+	if Ed448.IsElement(Ed448.Identity()) {
+		t.Errorf("identity should not be accepted as a group element")
+	}
+
+	if !eq(Ed448.Exp(g, q), Ed448.Identity()) {
+		t.Errorf("q*G should be the identity")
+	}
+
+	if !eq(Ed448.Mul(g, Ed448.Inverse(g)), Ed448.Identity()) {
+		t.Errorf("G + (-G) should be the identity")
+	}
+
+	x := Ed448.Exp(g, big.NewInt(12345))
+	dec, err := Ed448.Decode(Ed448.Encode(x))
+	if err != nil || !eq(dec, x) {
+		t.Errorf("failed to decode an encoded point: %v", err)
+	}
+}
+
+// exchange follows the SMP overview of the OTRv4 spec: Alice starts with a
+// question, Bob answers, and every message goes through its TLV encoding
+func exchange(t *testing.T, aliceSecret, bobSecret string) (smp.Message, smp.Message) {
+	aliceFingerprint := Fingerprint(bytes.Repeat([]byte{0xA1}, pointSize), bytes.Repeat([]byte{0xA2}, pointSize))
+	bobFingerprint := Fingerprint(bytes.Repeat([]byte{0xB1}, pointSize), bytes.Repeat([]byte{0xB2}, pointSize))
+	ssid := []byte{1, 2, 3, 4, 5, 6, 7, 8}
+
+	alice := NewProtocol()
+	alice.Question = "Where did we meet?"
+	alice.Secret = GenerateSecret(aliceFingerprint, bobFingerprint, ssid, []byte(aliceSecret))
+
+	bob := NewProtocol()
+	bob.Secret = GenerateSecret(aliceFingerprint, bobFingerprint, ssid, []byte(bobSecret))
+
+	transfer := func(m smp.Message) smp.Message {
+		tlv, err := Encode(m)
+		if err != nil {
+			t.Fatalf("failed to encode %T: %s", m, err)
+		}
+
+		dec, err := Decode(tlv)
+		if err != nil {
+			t.Fatalf("failed to decode %T: %s", m, err)
+		}
+
+		return dec
+	}
+
+	m1, err := alice.Compare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := bob.Receive(transfer(m1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m3, err := alice.Receive(transfer(m2))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m4, err := bob.Receive(transfer(m3))
+	if _, ok := m4.(smp.SMPAbort); ok {
+		return m4, nil
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	last, err := alice.Receive(transfer(m4))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return m4, last
+}
+
+func TestSMPWithMatchingSecrets(t *testing.T) {
+	m4, last := exchange(t, "in the park", "in the park")
+
+	if _, ok := m4.(smp.SMP4); !ok {
+		t.Errorf("expected Bob to send SMP4, got %T", m4)
+	}
+
+	if last != nil {
+		t.Errorf("expected Alice to finish the protocol, got %T", last)
+	}
+}
+
+func TestSMPWithDifferentSecrets(t *testing.T) {
+	m4, _ := exchange(t, "in the park", "at the beach")
+
+	if _, ok := m4.(smp.SMPAbort); !ok {
+		t.Errorf("expected Bob to abort, got %T", m4)
+	}
+}
+
+func TestSMP1TLVCarriesTheQuestion(t *testing.T) {
+	p := NewProtocol()
+	p.Secret = big.NewInt(1)
+
+	m, err := p.Compare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	mq, _ := smp.NewSMP1Q("question?", m.MPIs()...)
+	tlv, err := Encode(mq)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// type, length, DATA(question)
+	if !bytes.Equal(tlv[:2], []byte{0x00, 0x02}) || !bytes.Equal(tlv[4:8], []byte{0, 0, 0, 9}) {
+		t.Errorf("unexpected tlv header %x", tlv[:8])
+	}
+
+	dec, err := Decode(tlv)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if q, ok := dec.(*smp.SMP1Q); !ok || q.Question() != "question?" {
+		t.Errorf("expected to decode the question, got %#v", dec)
+	}
+}
+
+func TestDecodeRejectsTrailingData(t *testing.T) {
+	tlv := TLV{0x00, 0x06, 0x00, 0x01, 0xff}
+	if _, err := Decode(tlv); err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestEd448MultiExp(t *testing.T) {
+	p := Ed448.Exp(g, big.NewInt(3))
+	r := Ed448.Exp(g, big.NewInt(5))
+
+	// 7 + 3*11 + 5*13
+	expected := Ed448.Exp(g, big.NewInt(105))
+
+	for _, c := range []struct {
+		bases []*big.Int
+		exps  []int64
+	}{
+		{[]*big.Int{g, p, r}, []int64{7, 11, 13}},
+		{[]*big.Int{p, g, r}, []int64{11, 7, 13}},
+		{[]*big.Int{p, r, g}, []int64{11, 13, 7}},
+		{[]*big.Int{p, r, Ed448.Exp(g, big.NewInt(7))}, []int64{11, 13, 1}},
+	} {
+		exps := make([]*big.Int, len(c.exps))
+		for i, e := range c.exps {
+			exps[i] = big.NewInt(e)
+		}
+
+		if got := Ed448.(smp.MultiExponentiator).MultiExp(c.bases, exps); !eq(got, expected) {
+			t.Errorf("MultiExp with exponents %v is wrong", c.exps)
+		}
+	}
+}
+
+func BenchmarkEd448MultiExp(b *testing.B) {
+	bases := []*big.Int{g, Ed448.Exp(g, big.NewInt(3)), Ed448.Exp(g, big.NewInt(5))}
+	exps := []*big.Int{new(big.Int).Rsh(q, 1), new(big.Int).Rsh(q, 2), new(big.Int).Rsh(q, 3)}
+
+	b.Run("multiexp", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Ed448.(smp.MultiExponentiator).MultiExp(bases, exps)
+		}
+	})
+
+	b.Run("separate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Ed448.Mul(Ed448.Mul(Ed448.Exp(bases[0], exps[0]), Ed448.Exp(bases[1], exps[1])), Ed448.Exp(bases[2], exps[2]))
+		}
+	})
+}
@@ -0,0 +1,173 @@
+package otrv4
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/juniorz/smp"
+)
+
+const (
+	tlvTypeSMP1     = uint16(0x02)
+	tlvTypeSMP2     = uint16(0x03)
+	tlvTypeSMP3     = uint16(0x04)
+	tlvTypeSMP4     = uint16(0x05)
+	tlvTypeSMPAbort = uint16(0x06)
+)
+
+type fieldType int
+
+const (
+	point fieldType = iota
+	scalar
+)
+
+// layouts are the field types of every SMP message, in the same order as
+// their MPIs()
+var layouts = map[uint16][]fieldType{
+	tlvTypeSMP1: {
+		point, scalar, scalar, // G2a, c2, D2
+		point, scalar, scalar, // G3a, c3, D3
+	},
+	tlvTypeSMP2: {
+		point, scalar, scalar, // G2b, c2, D2
+		point, scalar, scalar, // G3b, c3, D3
+		point, point, // Pb, Qb
+		scalar, scalar, scalar, // cp, D5, D6
+	},
+	tlvTypeSMP3: {
+		point, point, // Pa, Qa
+		scalar, scalar, scalar, // cp, D5, D6
+		point,          // Ra
+		scalar, scalar, // cr, D7
+	},
+	tlvTypeSMP4: {
+		point,          // Rb
+		scalar, scalar, // cr, D7
+	},
+	tlvTypeSMPAbort: {},
+}
+
+// TLV represents an OTRv4 TLV record
+type TLV []byte
+
+// Encode returns the OTRv4 TLV for the SMP message m
+func Encode(m smp.Message) (TLV, error) {
+	var tp uint16
+	var question string
+
+	switch v := m.(type) {
+	case smp.SMP1, *smp.SMP1:
+		tp = tlvTypeSMP1
+	case smp.SMP1Q:
+		tp, question = tlvTypeSMP1, v.Question()
+	case *smp.SMP1Q:
+		tp, question = tlvTypeSMP1, v.Question()
+	case smp.SMP2, *smp.SMP2:
+		tp = tlvTypeSMP2
+	case smp.SMP3, *smp.SMP3:
+		tp = tlvTypeSMP3
+	case smp.SMP4, *smp.SMP4:
+		tp = tlvTypeSMP4
+	case smp.SMPAbort, *smp.SMPAbort:
+		tp = tlvTypeSMPAbort
+	default:
+		return nil, errors.New("unknown message")
+	}
+
+	var value []byte
+	if tp == tlvTypeSMP1 {
+		value = appendData(value, []byte(question))
+	}
+
+	for i, mpi := range m.MPIs() {
+		switch layouts[tp][i] {
+		case point:
+			value = appendPoint(value, mpi)
+		case scalar:
+			value = appendScalar(value, mpi)
+		}
+	}
+
+	if len(value) > 0xffff {
+		return nil, errors.New("tlv value too long")
+	}
+
+	return generateTLV(tp, value), nil
+}
+
+func generateTLV(tp uint16, value []byte) TLV {
+	data := make([]byte, 0, 4+len(value))
+	data = appendShort(data, tp)
+	data = appendShort(data, uint16(len(value)))
+	return append(data, value...)
+}
+
+// Decode returns the SMP message in the OTRv4 TLV m.
+// Group elements are not validated, this is done by the smp.Protocol.
+func Decode(m TLV) (smp.Message, error) {
+	tBytes, tType, ok := extractShort(m)
+	if !ok {
+		return nil, errors.New("wrong tlv type")
+	}
+
+	var tLen uint16
+	tBytes, tLen, ok = extractShort(tBytes)
+	if !ok {
+		return nil, errors.New("wrong tlv length")
+	}
+
+	if len(tBytes) < int(tLen) {
+		return nil, errors.New("wrong tlv value")
+	}
+
+	return parseTLV(tType, tBytes[:int(tLen)])
+}
+
+func parseTLV(t uint16, v []byte) (smp.Message, error) {
+	layout, ok := layouts[t]
+	if !ok {
+		return nil, errors.New("not a SMP tlv")
+	}
+
+	var question []byte
+	if t == tlvTypeSMP1 {
+		if v, question, ok = extractData(v); !ok {
+			return nil, errors.New("wrong question")
+		}
+	}
+
+	mpis := make([]*big.Int, len(layout))
+	for i, f := range layout {
+		switch f {
+		case point:
+			v, mpis[i], ok = extractPoint(v)
+		case scalar:
+			v, mpis[i], ok = extractScalar(v)
+		}
+
+		if !ok {
+			return nil, errors.New("wrong tlv value")
+		}
+	}
+
+	if len(v) != 0 {
+		return nil, errors.New("unexpected data at the end of the tlv")
+	}
+
+	switch t {
+	case tlvTypeSMP1:
+		if len(question) > 0 {
+			return smp.NewSMP1Q(string(question), mpis...)
+		}
+		return smp.NewSMP1(mpis...)
+	case tlvTypeSMP2:
+		return smp.NewSMP2(mpis...)
+	case tlvTypeSMP3:
+		return smp.NewSMP3(mpis...)
+	case tlvTypeSMP4:
+		return smp.NewSMP4(mpis...)
+	default:
+		return smp.NewSMPAbort(mpis...)
+	}
+}
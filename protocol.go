@@ -1,10 +1,14 @@
 package smp
 
 import (
+	"context"
 	"crypto/rand"
 	"errors"
+	"hash"
 	"io"
 	"math/big"
+	"sync"
+	"time"
 )
 
 const Version = 1
@@ -29,7 +33,7 @@ const (
 
 var (
 	errUnspecifiedSecret = errors.New("missing secret")
-	errShortRandomRead   = errors.New("short read from rand source")
+	errNothingToRespond  = errors.New("no SMP1 waiting for a secret")
 )
 
 // Message represents an SMP message
@@ -40,18 +44,78 @@ type Message interface {
 
 // Options represents configuration options for the SMP protocol
 type Options interface {
+	// ParameterLength was the length in bytes of the random exponents.
+	//
+	// Deprecated: ParameterLength is ignored. Exponents are sampled uniformly
+	// from the order of the group.
 	ParameterLength() int
 	IsGroupElement(*big.Int) bool
 }
 
-// Protocol represents the SMP protocol
+// GroupOptions are Options that select the group the protocol runs over.
+// Protocols created with other Options run over MODP1536. IsGroupElement must
+// only accept elements of this group.
+type GroupOptions interface {
+	Options
+	Group() Group
+}
+
+// HashOptions are Options that select the hash function of the zero
+// knowledge proofs and a domain separation prefix, hashed before the index of
+// every proof. Protocols created with other Options use SHA-256 without
+// prefix, as defined by OTRv3. Groups that implement ProofHasher define their
+// own hash and ignore these.
+type HashOptions interface {
+	Options
+	ProofHash() func() hash.Hash
+	DomainSeparation() []byte
+}
+
+// Protocol represents the SMP protocol.
+//
+// A Protocol is safe for concurrent use by multiple goroutines: every method
+// is serialized by an internal lock. The exported fields must be set before
+// the Protocol is shared, or while no other goroutine uses it. Secret can
+// also be given to Respond. The protocol works on its own copy of the secret,
+// and never modifies the caller's.
+//
+// The package-level settings SecretExpBackend and FixedBaseTeeth are read
+// without synchronization: they must be set before the first Protocol runs.
 type Protocol struct {
 	Options
+	group    Group
 	Rand     io.Reader
 	Question string
 	Secret   *big.Int
 
+	// EventHandler, if set, receives every event in order
+	EventHandler EventHandler
+
+	// Transcript, if set, records every message sent and received
+	Transcript *Transcript
+
+	// StepTimeout, if not zero, is how long the protocol waits for each
+	// reply of the peer before it aborts itself with a TimedOut event
+	StepTimeout time.Duration
+
+	// mu serializes the state changes made by the caller and by the
+	// deadline of the current step
+	mu       sync.Mutex
+	stopWait chan struct{}
+
 	eventC chan Event
+	closed bool
+
+	// queued are the events for the EventHandler, delivered by unlock
+	queued     []EventDetail
+	delivering bool
+
+	proofHash proofHash
+	pending   *SMP1Q
+
+	// secret is our copy of the secret compared by the current exchange
+	secret *big.Int
+
 	smpState
 	s1 *smp1State
 	s2 *smp2State
@@ -61,53 +125,189 @@ type Protocol struct {
 
 // NewProtocol returns an SMP protocol
 func NewProtocol(options Options) *Protocol {
-	return &Protocol{
+	p := &Protocol{
 		Options:  options,
+		group:    MODP1536,
 		smpState: smpStateExpect1{},
 		Rand:     rand.Reader,
-		eventC:   make(chan Event, 1),
 	}
+
+	if o, ok := options.(GroupOptions); ok {
+		p.group = o.Group()
+	}
+
+	if o, ok := options.(HashOptions); ok {
+		p.proofHash = proofHash{new: o.ProofHash(), prefix: o.DomainSeparation()}
+	}
+
+	return p
+}
+
+// Group returns the group the protocol runs over, selected by its Options
+func (p *Protocol) Group() Group {
+	return p.group
 }
 
 // Receive process the incoming message and potentially returns a message
-// addressed to the other peer
+// addressed to the other peer. When the protocol is aborted, it returns the
+// SMPAbort to be sent along with the reason: an *InvalidGroupElementError,
+// *InvalidProofError or *UnexpectedMessageError, ErrSecretMismatch or
+// ErrRandomnessFailure.
 func (p *Protocol) Receive(m Message) (Message, error) {
-	send, err := m.received(p)
-	if err != nil {
-		p.event(Failure)
-		return nil, err
-	}
+	return p.ReceiveContext(context.Background(), m)
+}
+
+// ReceiveContext is like Receive, but the protocol also aborts itself if ctx
+// is done before the peer replies to the returned message
+func (p *Protocol) ReceiveContext(ctx context.Context, m Message) (Message, error) {
+	p.mu.Lock()
+	defer p.unlock()
 
-	return send, nil
+	p.stopWaiting()
+	before := p.smpState
+	ret, err := m.received(p)
+	p.record(Received, before, m)
+	p.record(Sent, before, ret)
+	p.awaitReply(ctx)
+
+	return ret, err
 }
 
 func (p *Protocol) Abort() (ret Message) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	p.stopWaiting()
+	before := p.smpState
+
 	//err is always nil
-	p.smpState, ret, _ = sendSMPAbortAndRestartStateMachine()
-	p.event(Abort)
+	p.smpState, ret, _ = sendSMPAbortAndRestartStateMachine(p)
+	p.record(Sent, before, ret)
+	p.event(Aborted{})
 	return
 }
 
 // Compare starts the protocol and generates a message addressed to the other
-// peer
+// peer: an SMP1Q if Question is set, or an SMP1 otherwise
 func (p *Protocol) Compare() (Message, error) {
+	return p.CompareContext(context.Background())
+}
+
+// CompareContext is like Compare, but the protocol also aborts itself if ctx
+// is done before the peer replies to the returned message
+func (p *Protocol) CompareContext(ctx context.Context) (Message, error) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	p.stopWaiting()
+	before := p.smpState
+	m, err := p.compare()
+	p.record(Sent, before, m)
+	p.awaitReply(ctx)
+
+	return m, err
+}
+
+func (p *Protocol) compare() (Message, error) {
 	if p.Secret == nil {
-		p.event(Failure)
+		p.event(Failed{Err: errUnspecifiedSecret})
 		return nil, errUnspecifiedSecret
 	}
 
-	m, err := p.newSMP1Message()
+	m, err := p.startMessage()
 	if err != nil {
-		p.event(Failure)
+		p.event(Failed{Err: err})
 		return nil, err
 	}
 
+	p.setSecret(p.Secret)
 	p.smpState = smpStateExpect2{}
-	p.event(InProgress)
+	p.pending = nil
+	p.event(Progress{Percent: 25, Step: 1})
 
 	return m, nil
 }
 
+// Restart aborts the current exchange and starts a new one, as the spec
+// allows: it returns an SMPAbort followed by the message returned by Compare,
+// to be sent to the peer in this order. The Secret and Question are kept.
+// Restarted is only emitted if an exchange was in progress.
+func (p *Protocol) Restart() ([]Message, error) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	p.stopWaiting()
+	defer p.awaitReply(context.Background())
+	before := p.smpState
+
+	if p.Secret == nil {
+		p.event(Failed{Err: errUnspecifiedSecret})
+		return nil, errUnspecifiedSecret
+	}
+
+	p.wipeStates()
+	p.smpState = smpStateExpect1{}
+	if !isIdle(before) {
+		p.event(Restarted{})
+	}
+
+	m, err := p.compare()
+	if err != nil {
+		return nil, err
+	}
+
+	msgs := []Message{SMPAbort{}, m}
+	p.record(Sent, before, msgs...)
+	return msgs, nil
+}
+
+// PendingQuestion returns the question asked by the peer, and whether the
+// protocol is waiting for Respond to be called. The question is empty if the
+// peer started the protocol with an SMP1.
+func (p *Protocol) PendingQuestion() (string, bool) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	if p.pending == nil {
+		return "", false
+	}
+
+	return p.pending.question, true
+}
+
+// Respond continues the protocol started by the peer, after an AskForSecret
+// event, comparing secret to theirs. Like Receive, it returns the message
+// addressed to the peer and the reason if the protocol is aborted.
+func (p *Protocol) Respond(secret *big.Int) (Message, error) {
+	return p.RespondContext(context.Background(), secret)
+}
+
+// RespondContext is like Respond, but the protocol also aborts itself if ctx
+// is done before the peer replies to the returned message
+func (p *Protocol) RespondContext(ctx context.Context, secret *big.Int) (ret Message, err error) {
+	p.mu.Lock()
+	defer p.unlock()
+
+	p.stopWaiting()
+	if _, ok := p.smpState.(smpStateAwaitingSecret); !ok {
+		return nil, errNothingToRespond
+	}
+
+	if secret == nil {
+		return nil, errUnspecifiedSecret
+	}
+
+	m := p.pending.SMP1
+	p.pending = nil
+	p.setSecret(secret)
+
+	before := p.smpState
+	p.smpState, ret, err = respondSMP1(p, m)
+	p.record(Sent, before, ret)
+	p.awaitReply(ctx)
+	return
+}
+
 func (p *Protocol) startMessage() (Message, error) {
 	if len(p.Question) > 0 {
 		return p.newSMP1QMessage(p.Question)
@@ -116,11 +316,60 @@ func (p *Protocol) startMessage() (Message, error) {
 	return p.newSMP1Message()
 }
 
-// Events returns the events channel for this Protocol
-func (p Protocol) Events() <-chan Event {
-	return p.eventC
+// Close aborts the protocol, wipes every secret value it holds (including its
+// copy of Secret) and closes the events channel. No events are emitted after Close.
+func (p *Protocol) Close() error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopWaiting()
+	p.smpState = smpStateExpect1{}
+	p.wipe()
+	p.closeEvents()
+
+	return nil
+}
+
+// wipe overwrites the intermediate values of the protocol and its copy of
+// the secret, and forgets the caller's Secret without modifying it. It is
+// called on every terminal transition.
+func (p *Protocol) wipe() {
+	p.wipeSecret()
+	p.Secret = nil
+}
+
+// wipeSecret overwrites the intermediate values of the protocol and its copy
+// of the secret, but keeps the caller's Secret
+func (p *Protocol) wipeSecret() {
+	p.wipeStates()
+	wipe(p.secret)
+	p.secret = nil
 }
 
-func (p Protocol) event(e Event) {
-	go func() { p.eventC <- e }()
+// setSecret replaces our copy of the secret with a copy of secret
+func (p *Protocol) setSecret(secret *big.Int) {
+	wipe(p.secret)
+	p.secret = new(big.Int).Set(secret)
+}
+
+// wipeStates overwrites the intermediate values of the protocol
+func (p *Protocol) wipeStates() {
+	if p.s1 != nil {
+		wipe(p.s1.values()...)
+	}
+
+	if p.s2 != nil {
+		wipe(p.s2.values()...)
+	}
+
+	if p.s3 != nil {
+		wipe(p.s3.values()...)
+	}
+
+	if p.s4 != nil {
+		wipe(p.s4.values()...)
+	}
+
+	p.s1, p.s2, p.s3, p.s4 = nil, nil, nil, nil
+	p.pending = nil
 }
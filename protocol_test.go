@@ -1,6 +1,540 @@
 package smp
 
-import "testing"
+import (
+	"bytes"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+	"time"
+)
 
 func TestProtocol(t *testing.T) {
 }
+
+func TestEventsAreDeliveredInOrderToTheHandler(t *testing.T) {
+	var aliceEvents, bobEvents []EventDetail
+
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	alice.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		aliceEvents = append(aliceEvents, e)
+	})
+
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+	bob.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		bobEvents = append(bobEvents, e)
+	})
+
+	m, _ := alice.Compare()
+	m, _ = bob.Receive(m)
+	m, _ = alice.Receive(m)
+	m, _ = bob.Receive(m)
+	alice.Receive(m)
+
+	expected := []EventDetail{
+		Progress{Percent: 25, Step: 1},
+		Progress{Percent: 75, Step: 3},
+		Succeeded{},
+	}
+	if !reflect.DeepEqual(aliceEvents, expected) {
+		t.Errorf("expected %v, got %v", expected, aliceEvents)
+	}
+
+	expected = []EventDetail{
+		Progress{Percent: 50, Step: 2},
+		Succeeded{},
+	}
+	if !reflect.DeepEqual(bobEvents, expected) {
+		t.Errorf("expected %v, got %v", expected, bobEvents)
+	}
+}
+
+func TestEventDetails(t *testing.T) {
+	var events []EventDetail
+	handler := EventHandlerFunc(func(e EventDetail) {
+		events = append(events, e)
+	})
+
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(1)
+	bob := NewProtocol(DefaultOptions())
+	bob.EventHandler = handler
+
+	m, _ := alice.Compare()
+	m1 := m.(SMP1)
+	bob.Receive(SMP1Q{SMP1: m1, question: "favorite color?"})
+
+	// a tampered proof
+	m1.d2 = new(big.Int).Add(m1.d2, big.NewInt(1))
+	bob.Secret = big.NewInt(1)
+	bob.Receive(m1)
+
+	bob.Receive(SMPAbort{})
+
+	expected := []EventDetail{
+		AskForSecret{Question: "favorite color?"},
+		CheatDetected{
+			Field:  "c2",
+			Reason: "is not a valid zero knowledge proof",
+			Err:    &InvalidProofError{Message: "SMP1", Proof: "c2"},
+		},
+		Aborted{ByPeer: true},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+
+	if events[1].Kind() != Cheated {
+		t.Errorf("expected kind %v, got %v", Cheated, events[1].Kind())
+	}
+}
+
+func TestEventsChannelIsBoundedAndClosedWithTheProtocol(t *testing.T) {
+	p := NewProtocol(DefaultOptions())
+	events := p.Events()
+
+	for i := 0; i < eventBufferSize+1; i++ {
+		p.Abort()
+	}
+
+	p.event(Succeeded{})
+	p.Close()
+
+	var received []Event
+	for e := range events {
+		received = append(received, e)
+	}
+
+	if len(received) != eventBufferSize {
+		t.Fatalf("expected %d events, got %d", eventBufferSize, len(received))
+	}
+
+	if last := received[len(received)-1]; last != Success {
+		t.Errorf("expected the most recent event to be kept, got %v", last)
+	}
+
+	p.Abort()
+}
+
+func isWiped(x *big.Int) bool {
+	limbs := x.Bits()
+	for _, l := range limbs[:cap(limbs)] {
+		if l != 0 {
+			return false
+		}
+	}
+
+	return x.Sign() == 0
+}
+
+// checkWiped checks the copies of the protocol are wiped and unreachable,
+// and the caller's secret is left alone
+func checkWiped(t *testing.T, name string, p *Protocol, values []*big.Int, secret *big.Int) {
+	if p.s1 != nil || p.s2 != nil || p.s3 != nil || p.s4 != nil || p.secret != nil || p.Secret != nil {
+		t.Errorf("%s: secret state is still reachable", name)
+	}
+
+	if !eq(secret, big.NewInt(42)) {
+		t.Errorf("%s: the caller's secret was modified", name)
+	}
+
+	for i, v := range values {
+		if !isWiped(v) {
+			t.Errorf("%s: value #%d was not wiped", name, i)
+		}
+	}
+}
+
+func TestSecretsAreWipedWhenTheProtocolEnds(t *testing.T) {
+	aliceSecret, bobSecret := big.NewInt(42), big.NewInt(42)
+
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = aliceSecret
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = bobSecret
+
+	m, _ := alice.Compare()
+	aliceValues := append(alice.s1.values(), alice.secret)
+
+	m, _ = bob.Receive(m)
+	bobValues := append(bob.s2.values(), bob.secret)
+
+	m, _ = alice.Receive(m)
+	aliceValues = append(aliceValues, alice.s3.values()...)
+
+	m, _ = bob.Receive(m)
+	checkWiped(t, "bob on success", bob, bobValues, bobSecret)
+
+	alice.Receive(m)
+	checkWiped(t, "alice on success", alice, aliceValues, aliceSecret)
+}
+
+func TestSecretsAreWipedOnAbortAndClose(t *testing.T) {
+	secret := big.NewInt(42)
+
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = secret
+	alice.Compare()
+	values := append(alice.s1.values(), alice.secret)
+
+	alice.Abort()
+	checkWiped(t, "abort", alice, values, secret)
+
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = secret
+	bob.Compare()
+	values = append(bob.s1.values(), bob.secret)
+
+	// the secret is kept for a restart by the peer
+	bob.Receive(SMPAbort{})
+	if bob.Secret != secret {
+		t.Errorf("abort by peer: the caller's secret was forgotten")
+	}
+	bob.Secret = nil
+	checkWiped(t, "abort by peer", bob, values, secret)
+
+	carol := NewProtocol(DefaultOptions())
+	carol.Secret = secret
+	carol.Compare()
+	values = append(carol.s1.values(), carol.secret)
+
+	carol.Close()
+	checkWiped(t, "close", carol, values, secret)
+
+	dave := NewProtocol(DefaultOptions())
+	dave.Secret = secret
+	dave.Compare()
+	values = append(dave.s1.values(), dave.secret)
+
+	dave.Receive(SMP3{})
+	checkWiped(t, "unexpected message", dave, values, secret)
+}
+
+func TestRespondKeepsTheCallersSecret(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+
+	m, _ := alice.Compare()
+	bob.Receive(m)
+
+	secret := big.NewInt(42)
+	bob.Respond(secret)
+	values := append(bob.s2.values(), bob.secret)
+
+	bob.Abort()
+	checkWiped(t, "abort after respond", bob, values, secret)
+}
+
+func TestResponderWithDeferredSecret(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+
+	if _, err := bob.Respond(big.NewInt(42)); err != errNothingToRespond {
+		t.Errorf("expected %v, got %v", errNothingToRespond, err)
+	}
+
+	m, _ := alice.Compare()
+	m1 := m.(SMP1)
+	m, err := bob.Receive(SMP1Q{SMP1: m1, question: "where?"})
+	if m != nil || err != nil {
+		t.Fatalf("expected bob to wait for a secret, got %T %v", m, err)
+	}
+
+	if q, ok := bob.PendingQuestion(); !ok || q != "where?" {
+		t.Errorf("expected a pending question, got %q %v", q, ok)
+	}
+
+	if m, err = bob.Respond(big.NewInt(42)); err != nil {
+		t.Fatal(err)
+	}
+
+	if _, ok := bob.PendingQuestion(); ok {
+		t.Errorf("expected no pending question after Respond")
+	}
+
+	m, _ = alice.Receive(m)
+	m, _ = bob.Receive(m)
+	if _, ok := m.(SMP4); !ok {
+		t.Errorf("expected SMP4, got %T", m)
+	}
+}
+
+func TestRespondFromTheEventHandler(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+
+	var reply Message
+	var events []EventDetail
+	bob.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		events = append(events, e)
+		if _, ok := e.(AskForSecret); ok {
+			reply, _ = bob.Respond(big.NewInt(42))
+		}
+	})
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		m, _ := alice.Compare()
+		bob.Receive(m)
+	}()
+
+	select {
+	case <-done:
+	case <-time.After(5 * time.Second):
+		t.Fatalf("Respond from the event handler deadlocked")
+	}
+
+	if _, ok := reply.(SMP2); !ok {
+		t.Fatalf("expected SMP2, got %T", reply)
+	}
+
+	expected := []EventDetail{
+		AskForSecret{},
+		Progress{Percent: 50, Step: 2},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+}
+
+func TestRestart(t *testing.T) {
+	var aliceEvents, bobEvents []EventDetail
+
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	alice.Question = "where?"
+	alice.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		aliceEvents = append(aliceEvents, e)
+	})
+
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+	bob.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		bobEvents = append(bobEvents, e)
+	})
+
+	m, _ := alice.Compare()
+	bob.Receive(m)
+
+	msgs, err := alice.Restart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(msgs) != 2 || msgs[0] != (SMPAbort{}) {
+		t.Fatalf("expected an SMPAbort followed by an SMP1Q, got %#v", msgs)
+	}
+
+	if m, ok := msgs[1].(SMP1Q); !ok || m.question != "where?" {
+		t.Fatalf("expected an SMP1Q, got %T", msgs[1])
+	}
+
+	if alice.s1 == nil || alice.Secret == nil {
+		t.Errorf("expected alice to keep her secret and start a new exchange")
+	}
+
+	for _, m := range msgs {
+		bob.Receive(m)
+	}
+
+	expected := []EventDetail{
+		Progress{Percent: 25, Step: 1},
+		Restarted{},
+		Progress{Percent: 25, Step: 1},
+	}
+	if !reflect.DeepEqual(aliceEvents, expected) {
+		t.Errorf("expected %v, got %v", expected, aliceEvents)
+	}
+
+	// bob keeps the secret he set in advance through the restart
+	expected = []EventDetail{
+		Progress{Percent: 50, Step: 2},
+		Aborted{ByPeer: true},
+		Restarted{ByPeer: true},
+		Progress{Percent: 50, Step: 2},
+	}
+	if !reflect.DeepEqual(bobEvents, expected) {
+		t.Errorf("expected %v, got %v", expected, bobEvents)
+	}
+}
+
+func TestRestartWhileIdleIsNotReported(t *testing.T) {
+	var events []EventDetail
+
+	p := NewProtocol(DefaultOptions())
+	p.Secret = big.NewInt(42)
+	p.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		events = append(events, e)
+	})
+
+	if _, err := p.Restart(); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := []EventDetail{Progress{Percent: 25, Step: 1}}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+}
+
+// restartAndFinish sends the messages of a Restart to the peer, which answers
+// the new exchange with the secret it kept, and runs the exchange to its end
+func restartAndFinish(t *testing.T, restarter, peer *Protocol) {
+	secret := restarter.Secret
+
+	msgs, err := restarter.Restart()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !eq(restarter.Secret, secret) || !eq(secret, big.NewInt(42)) {
+		t.Fatalf("Restart modified the secret")
+	}
+
+	if ret, err := peer.Receive(msgs[0]); ret != nil || err != nil {
+		t.Fatalf("unexpected reply to %T: %T %v", msgs[0], ret, err)
+	}
+
+	var succeeded []*Protocol
+	for _, p := range []*Protocol{restarter, peer} {
+		p := p
+		p.EventHandler = EventHandlerFunc(func(e EventDetail) {
+			if e == (Succeeded{}) {
+				succeeded = append(succeeded, p)
+			}
+		})
+	}
+
+	m, err := peer.Receive(msgs[1])
+	for from, to := peer, restarter; m != nil; from, to = to, from {
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		m, err = to.Receive(m)
+	}
+
+	if len(succeeded) != 2 {
+		t.Errorf("expected both sides to succeed after the restart")
+	}
+}
+
+func TestRestartFromExpect3(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+
+	m, _ := alice.Compare()
+	bob.Receive(m)
+
+	restartAndFinish(t, bob, alice)
+}
+
+func TestRestartFromExpect4(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+
+	m, _ := alice.Compare()
+	m, _ = bob.Receive(m)
+	alice.Receive(m)
+
+	restartAndFinish(t, alice, bob)
+}
+
+func TestStaleAbortIsNotARestart(t *testing.T) {
+	var events []EventDetail
+
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+	bob.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		events = append(events, e)
+	})
+
+	// bob is idle when the abort arrives
+	bob.Receive(SMPAbort{})
+
+	m, _ := alice.Compare()
+	bob.Receive(m)
+
+	expected := []EventDetail{
+		Aborted{ByPeer: true},
+		Progress{Percent: 50, Step: 2},
+	}
+	if !reflect.DeepEqual(events, expected) {
+		t.Errorf("expected %v, got %v", expected, events)
+	}
+}
+
+func TestUnexpectedMessagesWhileIdleKeepTheSecret(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+
+	m1, _ := alice.Compare()
+	m2, _ := bob.Receive(m1)
+
+	carol := NewProtocol(DefaultOptions())
+	carol.Secret = big.NewInt(42)
+	if ret, err := carol.Receive(m2); ret != (SMPAbort{}) || err == nil {
+		t.Fatalf("expected an abort, got %T %v", ret, err)
+	}
+
+	if carol.Secret == nil || carol.secret != nil {
+		t.Errorf("expected only the caller's secret to be kept")
+	}
+}
+
+func TestErrorsReachTheCaller(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(1)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(2)
+
+	m, _ := alice.Compare()
+	m1 := m.(SMP1)
+	m1.g3a = big.NewInt(1)
+
+	var ge *InvalidGroupElementError
+	ret, err := bob.Receive(m1)
+	if ret != (SMPAbort{}) || !errors.As(err, &ge) || ge.Message != "SMP1" || ge.Field != "g3a" {
+		t.Errorf("expected an invalid g3a, got %T %v", ret, err)
+	}
+
+	var ue *UnexpectedMessageError
+	_, err = bob.Receive(SMP3{})
+	if !errors.As(err, &ue) || ue.State != "SMPSTATE_EXPECT1" || ue.Got != "SMP3" {
+		t.Errorf("expected an unexpected message error, got %v", err)
+	}
+
+	bob.Secret = big.NewInt(2)
+	m, _ = bob.Receive(m)
+	m, _ = alice.Receive(m)
+
+	var failed error
+	bob.EventHandler = EventHandlerFunc(func(e EventDetail) {
+		failed = e.(Failed).Err
+	})
+
+	ret, err = bob.Receive(m)
+	if ret != (SMPAbort{}) || !errors.Is(err, ErrSecretMismatch) || !errors.Is(failed, ErrSecretMismatch) {
+		t.Errorf("expected the secret mismatch to be returned and notified, got %v %v", err, failed)
+	}
+
+	alice.Rand = bytes.NewReader(nil)
+	alice.Secret = big.NewInt(1)
+	if _, err = alice.Compare(); !errors.Is(err, ErrRandomnessFailure) {
+		t.Errorf("expected a randomness failure, got %v", err)
+	}
+}
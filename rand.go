@@ -5,26 +5,39 @@ import (
 	"math/big"
 )
 
-func (p Protocol) generateRandMPIs(mpis []*big.Int) (err error) {
-	b := make([]byte, p.ParameterLength())
-
+func (p *Protocol) generateRandMPIs(mpis []*big.Int) error {
 	for i := range mpis {
-		var r *big.Int
-		r, err = p.randMPI(b)
+		r, err := p.randExponent()
 		if err != nil {
-			return
+			return err
 		}
 
-		*mpis[i] = *r
+		mpis[i].Set(r)
+		wipe(r)
 	}
 
-	return
+	return nil
 }
 
-func (p Protocol) randMPI(buf []byte) (*big.Int, error) {
-	if _, err := io.ReadFull(p.Rand, buf); err != nil {
-		return nil, errShortRandomRead
-	}
+// randExponent returns an exponent chosen uniformly at random in [1, q-1],
+// where q is the order of the group, by rejection sampling
+func (p *Protocol) randExponent() (*big.Int, error) {
+	q := p.group.Order()
+	buf := make([]byte, (q.BitLen()+7)/8)
+	defer wipeBytes(buf)
+
+	mask := byte(0xff >> uint(len(buf)*8-q.BitLen()))
+	for {
+		if _, err := io.ReadFull(p.Rand, buf); err != nil {
+			return nil, ErrRandomnessFailure
+		}
 
-	return new(big.Int).SetBytes(buf), nil
+		buf[0] &= mask
+		x := new(big.Int).SetBytes(buf)
+		if x.Sign() > 0 && x.Cmp(q) < 0 {
+			return x, nil
+		}
+
+		wipe(x)
+	}
 }
@@ -0,0 +1,148 @@
+// Package ristretto provides a ristretto255 group for the SMP protocol.
+// Elements are 32-byte ristretto255 encodings, which makes SMP messages much
+// smaller and faster to compute than over the RFC3526 MODP group.
+package ristretto
+
+import (
+	"errors"
+	"math/big"
+
+	"github.com/gtank/ristretto255"
+	"github.com/juniorz/smp"
+)
+
+const (
+	elementSize = 32
+	scalarSize  = 32
+)
+
+var (
+	// l is the order of the ristretto255 group
+	l *big.Int
+	// g is the encoding of the ristretto255 base point
+	g *big.Int
+
+	// Ristretto255 is the ristretto255 prime order group
+	Ristretto255 smp.Group = group{}
+
+	errInvalidElement = errors.New("invalid ristretto255 element")
+)
+
+func init() {
+	l, _ = new(big.Int).SetString("7237005577332262213973186563042994240857116359379907606001950938285454250989", 10)
+	g = elementToInt(ristretto255.NewElement().Base())
+}
+
+type group struct{}
+
+func (group) Generator() *big.Int {
+	return g
+}
+
+func (group) Order() *big.Int {
+	return l
+}
+
+func (group) Identity() *big.Int {
+	return elementToInt(ristretto255.NewElement().Zero())
+}
+
+func (group) Exp(b, x *big.Int) *big.Int {
+	s := intToScalar(x)
+
+	if b.Cmp(g) == 0 {
+		return elementToInt(ristretto255.NewElement().ScalarBaseMult(s))
+	}
+
+	return elementToInt(ristretto255.NewElement().ScalarMult(s, mustElement(b)))
+}
+
+// MultiExp implements smp.MultiExponentiator with a variable time
+// multi-scalar multiplication
+func (group) MultiExp(bases, exps []*big.Int) *big.Int {
+	scalars := make([]*ristretto255.Scalar, len(exps))
+	elements := make([]*ristretto255.Element, len(bases))
+	for i := range bases {
+		scalars[i] = intToScalar(exps[i])
+		elements[i] = mustElement(bases[i])
+	}
+
+	return elementToInt(ristretto255.NewElement().VarTimeMultiScalarMult(scalars, elements))
+}
+
+func (group) Mul(x, y *big.Int) *big.Int {
+	return elementToInt(ristretto255.NewElement().Add(mustElement(x), mustElement(y)))
+}
+
+func (group) Inverse(b *big.Int) *big.Int {
+	return elementToInt(ristretto255.NewElement().Negate(mustElement(b)))
+}
+
+// IsElement checks b is the canonical encoding of an element other than the
+// identity
+func (group) IsElement(b *big.Int) bool {
+	e, err := intToElement(b)
+	if err != nil {
+		return false
+	}
+
+	return e.Equal(ristretto255.NewElement().Zero()) == 0
+}
+
+func (group) Encode(b *big.Int) []byte {
+	return b.FillBytes(make([]byte, elementSize))
+}
+
+func (group) Decode(b []byte) (*big.Int, error) {
+	if len(b) != elementSize {
+		return nil, errInvalidElement
+	}
+
+	x := new(big.Int).SetBytes(b)
+	if !Ristretto255.IsElement(x) {
+		return nil, errInvalidElement
+	}
+
+	return x, nil
+}
+
+func elementToInt(e *ristretto255.Element) *big.Int {
+	return new(big.Int).SetBytes(e.Encode(nil))
+}
+
+func intToElement(x *big.Int) (*ristretto255.Element, error) {
+	if x.Sign() < 0 || x.BitLen() > elementSize*8 {
+		return nil, errInvalidElement
+	}
+
+	e := ristretto255.NewElement()
+	if err := e.Decode(x.FillBytes(make([]byte, elementSize))); err != nil {
+		return nil, err
+	}
+
+	return e, nil
+}
+
+// mustElement decodes an element that has already been validated
+func mustElement(x *big.Int) *ristretto255.Element {
+	e, err := intToElement(x)
+	if err != nil {
+		panic(err)
+	}
+
+	return e
+}
+
+func intToScalar(x *big.Int) *ristretto255.Scalar {
+	b := new(big.Int).Mod(x, l).FillBytes(make([]byte, scalarSize))
+	for i, j := 0, len(b)-1; i < j; i, j = i+1, j-1 {
+		b[i], b[j] = b[j], b[i]
+	}
+
+	s := ristretto255.NewScalar()
+	if err := s.Decode(b); err != nil {
+		panic(err)
+	}
+
+	return s
+}
@@ -0,0 +1,122 @@
+package ristretto
+
+import (
+	"errors"
+	"math/big"
+	"testing"
+
+	"github.com/juniorz/smp"
+)
+
+func TestRistretto255Group(t *testing.T) {
+	gen := Ristretto255.Generator()
+
+	if !Ristretto255.IsElement(gen) {
+		t.Errorf("generator is not a group element")
+	}
+
+	if Ristretto255.Exp(gen, l).Cmp(Ristretto255.Identity()) != 0 {
+		t.Errorf("l*G should be the identity")
+	}
+
+	if Ristretto255.Mul(gen, Ristretto255.Inverse(gen)).Cmp(Ristretto255.Identity()) != 0 {
+		t.Errorf("G + (-G) should be the identity")
+	}
+
+	if len(Ristretto255.Encode(gen)) != elementSize {
+		t.Errorf("elements should be encoded in %d bytes", elementSize)
+	}
+}
+
+func TestRistretto255MultiExp(t *testing.T) {
+	gen := Ristretto255.Generator()
+	bases := []*big.Int{gen, Ristretto255.Exp(gen, big.NewInt(3)), Ristretto255.Exp(gen, big.NewInt(5))}
+	exps := []*big.Int{big.NewInt(7), big.NewInt(11), big.NewInt(13)}
+
+	// 7 + 3*11 + 5*13
+	expected := Ristretto255.Exp(gen, big.NewInt(105))
+	if got := Ristretto255.(smp.MultiExponentiator).MultiExp(bases, exps); got.Cmp(expected) != 0 {
+		t.Errorf("got %x, expected %x", got, expected)
+	}
+}
+
+func BenchmarkRistretto255MultiExp(b *testing.B) {
+	gen := Ristretto255.Generator()
+	bases := []*big.Int{gen, Ristretto255.Exp(gen, big.NewInt(3)), Ristretto255.Exp(gen, big.NewInt(5))}
+	exps := []*big.Int{new(big.Int).Rsh(l, 1), new(big.Int).Rsh(l, 2), new(big.Int).Rsh(l, 3)}
+
+	b.Run("multiexp", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			Ristretto255.(smp.MultiExponentiator).MultiExp(bases, exps)
+		}
+	})
+
+	b.Run("separate", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			r := Ristretto255.Exp(bases[0], exps[0])
+			r = Ristretto255.Mul(r, Ristretto255.Exp(bases[1], exps[1]))
+			Ristretto255.Mul(r, Ristretto255.Exp(bases[2], exps[2]))
+		}
+	})
+}
+
+func run(t *testing.T, a, b int64) smp.Message {
+	alice := smp.NewProtocol(Options{})
+	alice.Secret = big.NewInt(a)
+	bob := smp.NewProtocol(Options{})
+	bob.Secret = big.NewInt(b)
+
+	if alice.Group() != Ristretto255 {
+		t.Fatalf("Options did not select the ristretto255 group")
+	}
+
+	m1, err := alice.Compare()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m2, err := bob.Receive(m1)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for _, mpi := range m2.MPIs() {
+		if mpi.BitLen() > elementSize*8 {
+			t.Errorf("SMP2 value larger than %d bytes", elementSize)
+		}
+	}
+
+	m3, err := alice.Receive(m2)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m4, err := bob.Receive(m3)
+	if err != nil && err != smp.ErrSecretMismatch {
+		t.Fatal(err)
+	}
+
+	return m4
+}
+
+func TestSMPOverRistretto255(t *testing.T) {
+	if m, ok := run(t, 42, 42).(smp.SMP4); !ok {
+		t.Errorf("expected SMP4, got %T", m)
+	}
+
+	if m, ok := run(t, 42, 43).(smp.SMPAbort); !ok {
+		t.Errorf("expected SMPAbort, got %T", m)
+	}
+}
+
+func TestInvalidElementsAreRejected(t *testing.T) {
+	// 4 is not the encoding of a ristretto255 element
+	four := big.NewInt(4)
+	m, _ := smp.NewSMP1(four, big.NewInt(1), big.NewInt(1), four, big.NewInt(1), big.NewInt(1))
+
+	var ge *smp.InvalidGroupElementError
+	ret, err := smp.NewProtocol(Options{}).Receive(*m)
+	if ret != (smp.SMPAbort{}) || !errors.As(err, &ge) || ge.Field != "g2a" {
+		t.Errorf("expected an invalid g2a, got %T %v", ret, err)
+	}
+}
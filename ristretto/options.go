@@ -0,0 +1,26 @@
+package ristretto
+
+import (
+	"math/big"
+
+	"github.com/juniorz/smp"
+)
+
+// Options selects the ristretto255 group for a smp.Protocol
+type Options struct{}
+
+// ParameterLength is the length of a scalar.
+//
+// Deprecated: ParameterLength is ignored by smp.Protocol.
+func (Options) ParameterLength() int {
+	return scalarSize
+}
+
+func (Options) IsGroupElement(x *big.Int) bool {
+	return Ristretto255.IsElement(x)
+}
+
+// Group implements smp.GroupOptions
+func (Options) Group() smp.Group {
+	return Ristretto255
+}
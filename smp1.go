@@ -10,12 +10,16 @@ type smp1State struct {
 	r2, r3 *big.Int
 }
 
-func (s smp1State) message() SMP1 {
+func (s *smp1State) values() []*big.Int {
+	return []*big.Int{s.a2, s.a3, s.r2, s.r3}
+}
+
+func (s smp1State) message(g Group, h proofHash) SMP1 {
 	m := SMP1{}
-	m.g2a = modExp(G1, s.a2)
-	m.g3a = modExp(G1, s.a3)
-	m.c2, m.d2 = generateZKP(s.r2, s.a2, 1)
-	m.c3, m.d3 = generateZKP(s.r3, s.a3, 2)
+	m.g2a = expSecret(g, g.Generator(), s.a2)
+	m.g3a = expSecret(g, g.Generator(), s.a3)
+	m.c2, m.d2 = generateZKP(g, h, s.r2, s.a2, 1)
+	m.c3, m.d3 = generateZKP(g, h, s.r3, s.a3, 2)
 
 	return m
 }
@@ -68,15 +72,14 @@ func (m SMP1) MPIs() []*big.Int {
 
 func (p *Protocol) newSMP1Message() (m SMP1, err error) {
 	if p.s1, err = p.newSMP1State(); err != nil {
-		p.event(Failure)
 		return
 	}
 
-	m = p.s1.message()
+	m = p.s1.message(p.group, p.proofHash)
 	return
 }
 
-func (p Protocol) newSMP1State() (s *smp1State, err error) {
+func (p *Protocol) newSMP1State() (s *smp1State, err error) {
 	s = &smp1State{
 		a2: new(big.Int),
 		a3: new(big.Int),
@@ -91,21 +94,23 @@ func (p Protocol) newSMP1State() (s *smp1State, err error) {
 	return
 }
 
-func (p Protocol) verifySMP1(msg SMP1) error {
+func (p *Protocol) verifySMP1(msg SMP1) error {
 	if !p.IsGroupElement(msg.g2a) {
-		return errors.New("g2a is an invalid group element")
+		return invalidGroupElement("SMP1", "g2a")
 	}
 
 	if !p.IsGroupElement(msg.g3a) {
-		return errors.New("g3a is an invalid group element")
-	}
-
-	if !verifyZKP(msg.d2, msg.g2a, msg.c2, 1) {
-		return errors.New("c2 is not a valid zero knowledge proof")
+		return invalidGroupElement("SMP1", "g3a")
 	}
 
-	if !verifyZKP(msg.d3, msg.g3a, msg.c3, 2) {
-		return errors.New("c3 is not a valid zero knowledge proof")
+	switch verifyProofs(
+		func() bool { return verifyZKP(p.group, p.proofHash, msg.d2, msg.g2a, msg.c2, 1) },
+		func() bool { return verifyZKP(p.group, p.proofHash, msg.d3, msg.g3a, msg.c3, 2) },
+	) {
+	case 0:
+		return invalidProof("SMP1", "c2")
+	case 1:
+		return invalidProof("SMP1", "c3")
 	}
 
 	return nil
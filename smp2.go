@@ -1,9 +1,6 @@
 package smp
 
-import (
-	"errors"
-	"math/big"
-)
+import "math/big"
 
 type smp2State struct {
 	y                  *big.Int
@@ -14,31 +11,42 @@ type smp2State struct {
 	pb, qb             *big.Int
 }
 
-func (s *smp2State) message(s1 SMP1) SMP2 {
+func (s *smp2State) values() []*big.Int {
+	return []*big.Int{
+		s.y,
+		s.b2, s.b3,
+		s.r2, s.r3, s.r4, s.r5, s.r6,
+		s.g2, s.g3,
+		s.g3a,
+		s.pb, s.qb,
+	}
+}
+
+func (s *smp2State) message(g Group, h proofHash, s1 SMP1) SMP2 {
 	var m SMP2
 
-	m.g2b = modExp(G1, s.b2)
-	m.g3b = modExp(G1, s.b3)
+	m.g2b = expSecret(g, g.Generator(), s.b2)
+	m.g3b = expSecret(g, g.Generator(), s.b3)
 
-	m.c2, m.d2 = generateZKP(s.r2, s.b2, 3)
-	m.c3, m.d3 = generateZKP(s.r3, s.b3, 4)
+	m.c2, m.d2 = generateZKP(g, h, s.r2, s.b2, 3)
+	m.c3, m.d3 = generateZKP(g, h, s.r3, s.b3, 4)
 
-	s.g3a = s1.g3a
-	s.g2 = modExp(s1.g2a, s.b2)
-	s.g3 = modExp(s1.g3a, s.b3)
+	s.g3a = new(big.Int).Set(s1.g3a)
+	s.g2 = expSecret(g, s1.g2a, s.b2)
+	s.g3 = expSecret(g, s1.g3a, s.b3)
 
-	s.pb = modExp(s.g3, s.r4)
-	s.qb = mulMod(modExp(G1, s.r4), modExp(s.g2, s.y), P)
+	s.pb = expSecret(g, s.g3, s.r4)
+	s.qb = g.Mul(expSecret(g, g.Generator(), s.r4), expSecret(g, s.g2, s.y))
 
-	m.pb = s.pb
-	m.qb = s.qb
+	m.pb = new(big.Int).Set(s.pb)
+	m.qb = new(big.Int).Set(s.qb)
 
-	m.cp = hashMPIsBN(nil, 5,
-		modExp(s.g3, s.r5),
-		mulMod(modExp(G1, s.r5), modExp(s.g2, s.r6), P))
+	m.cp = hashElements(g, h, 5,
+		expSecret(g, s.g3, s.r5),
+		g.Mul(expSecret(g, g.Generator(), s.r5), expSecret(g, s.g2, s.r6)))
 
-	m.d5 = subMod(s.r5, mul(s.r4, m.cp), Q)
-	m.d6 = subMod(s.r6, mul(s.y, m.cp), Q)
+	m.d5 = subMod(s.r5, mul(s.r4, m.cp), g.Order())
+	m.d6 = subMod(s.r6, mul(s.y, m.cp), g.Order())
 
 	return m
 }
@@ -88,18 +96,17 @@ func (m SMP2) MPIs() []*big.Int {
 
 func (p *Protocol) newSMP2Message(m1 SMP1) (m SMP2, err error) {
 	if p.s2, err = p.newSMP2State(); err != nil {
-		p.event(Failure)
 		return
 	}
 
-	m = p.s2.message(m1)
+	m = p.s2.message(p.group, p.proofHash, m1)
 
 	return
 }
 
-func (p Protocol) newSMP2State() (s *smp2State, err error) {
+func (p *Protocol) newSMP2State() (s *smp2State, err error) {
 	s = &smp2State{
-		y: p.Secret,
+		y: new(big.Int).Set(p.secret),
 
 		b2: new(big.Int),
 		b3: new(big.Int),
@@ -117,36 +124,38 @@ func (p Protocol) newSMP2State() (s *smp2State, err error) {
 	return
 }
 
-func (p Protocol) verifySMP2(msg SMP2) error {
+func (p *Protocol) verifySMP2(msg SMP2) error {
 	if !p.IsGroupElement(msg.g2b) {
-		return errors.New("g2b is an invalid group element")
+		return invalidGroupElement("SMP2", "g2b")
 	}
 
 	if !p.IsGroupElement(msg.g3b) {
-		return errors.New("g3b is an invalid group element")
+		return invalidGroupElement("SMP2", "g3b")
 	}
 
 	if !p.IsGroupElement(msg.pb) {
-		return errors.New("Pb is an invalid group element")
+		return invalidGroupElement("SMP2", "Pb")
 	}
 
 	if !p.IsGroupElement(msg.qb) {
-		return errors.New("Qb is an invalid group element")
+		return invalidGroupElement("SMP2", "Qb")
 	}
 
-	if !verifyZKP(msg.d2, msg.g2b, msg.c2, 3) {
-		return errors.New("c2 is not a valid zero knowledge proof")
-	}
-
-	if !verifyZKP(msg.d3, msg.g3b, msg.c3, 4) {
-		return errors.New("c3 is not a valid zero knowledge proof")
-	}
-
-	g2 := modExp(msg.g2b, p.s1.a2)
-	g3 := modExp(msg.g3b, p.s1.a3)
-
-	if !verifyZKP2(g2, g3, msg.d5, msg.d6, msg.pb, msg.qb, msg.cp, 5) {
-		return errors.New("cP is not a valid zero knowledge proof")
+	switch verifyProofs(
+		func() bool { return verifyZKP(p.group, p.proofHash, msg.d2, msg.g2b, msg.c2, 3) },
+		func() bool { return verifyZKP(p.group, p.proofHash, msg.d3, msg.g3b, msg.c3, 4) },
+		func() bool {
+			g2 := expSecret(p.group, msg.g2b, p.s1.a2)
+			g3 := expSecret(p.group, msg.g3b, p.s1.a3)
+			return verifyZKP2(p.group, p.proofHash, g2, g3, msg.d5, msg.d6, msg.pb, msg.qb, msg.cp, 5)
+		},
+	) {
+	case 0:
+		return invalidProof("SMP2", "c2")
+	case 1:
+		return invalidProof("SMP2", "c3")
+	case 2:
+		return invalidProof("SMP2", "cP")
 	}
 
 	return nil
@@ -1,9 +1,6 @@
 package smp
 
-import (
-	"errors"
-	"math/big"
-)
+import "math/big"
 
 type smp3State struct {
 	x              *big.Int
@@ -12,27 +9,36 @@ type smp3State struct {
 	qaqb, papb     *big.Int
 }
 
-func (s *smp3State) message(s1 *smp1State, m2 SMP2) SMP3 {
+func (s *smp3State) values() []*big.Int {
+	return []*big.Int{
+		s.x,
+		s.g3b,
+		s.r4, s.r5, s.r6, s.r7,
+		s.qaqb, s.papb,
+	}
+}
+
+func (s *smp3State) message(g Group, h proofHash, s1 *smp1State, m2 SMP2) SMP3 {
 	var m SMP3
 
-	g2 := modExp(m2.g2b, s1.a2)
-	g3 := modExp(m2.g3b, s1.a3)
+	g2 := expSecret(g, m2.g2b, s1.a2)
+	g3 := expSecret(g, m2.g3b, s1.a3)
 
-	m.pa = modExp(g3, s.r4)
-	m.qa = mulMod(modExp(G1, s.r4), modExp(g2, s.x), P)
+	m.pa = expSecret(g, g3, s.r4)
+	m.qa = g.Mul(expSecret(g, g.Generator(), s.r4), expSecret(g, g2, s.x))
 
-	s.g3b = m2.g3b
-	s.qaqb = divMod(m.qa, m2.qb, P)
-	s.papb = divMod(m.pa, m2.pb, P)
+	s.g3b = new(big.Int).Set(m2.g3b)
+	s.qaqb = div(g, m.qa, m2.qb)
+	s.papb = div(g, m.pa, m2.pb)
 
-	m.cp = hashMPIsBN(nil, 6, modExp(g3, s.r5), mulMod(modExp(G1, s.r5), modExp(g2, s.r6), P))
-	m.d5 = generateDZKP(s.r5, s.r4, m.cp)
-	m.d6 = generateDZKP(s.r6, s.x, m.cp)
+	m.cp = hashElements(g, h, 6, expSecret(g, g3, s.r5), g.Mul(expSecret(g, g.Generator(), s.r5), expSecret(g, g2, s.r6)))
+	m.d5 = generateDZKP(g, s.r5, s.r4, m.cp)
+	m.d6 = generateDZKP(g, s.r6, s.x, m.cp)
 
-	m.ra = modExp(s.qaqb, s1.a3)
+	m.ra = expSecret(g, s.qaqb, s1.a3)
 
-	m.cr = hashMPIsBN(nil, 7, modExp(G1, s.r7), modExp(s.qaqb, s.r7))
-	m.d7 = subMod(s.r7, mul(s1.a3, m.cr), Q)
+	m.cr = hashElements(g, h, 7, expSecret(g, g.Generator(), s.r7), expSecret(g, s.qaqb, s.r7))
+	m.d7 = subMod(s.r7, mul(s1.a3, m.cr), g.Order())
 
 	return m
 }
@@ -78,18 +84,17 @@ func (m SMP3) MPIs() []*big.Int {
 
 func (p *Protocol) newSMP3Message(m2 SMP2) (m SMP3, err error) {
 	if p.s3, err = p.newSMP3State(); err != nil {
-		p.event(Failure)
 		return
 	}
 
-	m = p.s3.message(p.s1, m2)
+	m = p.s3.message(p.group, p.proofHash, p.s1, m2)
 
 	return
 }
 
-func (p Protocol) newSMP3State() (s *smp3State, err error) {
+func (p *Protocol) newSMP3State() (s *smp3State, err error) {
 	s = &smp3State{
-		x: p.Secret,
+		x: new(big.Int).Set(p.secret),
 
 		r4: new(big.Int),
 		r5: new(big.Int),
@@ -104,40 +109,44 @@ func (p Protocol) newSMP3State() (s *smp3State, err error) {
 	return
 }
 
-func (p Protocol) verifySMP3(msg SMP3) error {
+func (p *Protocol) verifySMP3(msg SMP3) error {
 	if !p.IsGroupElement(msg.pa) {
-		return errors.New("Pa is an invalid group element")
+		return invalidGroupElement("SMP3", "Pa")
 	}
 
 	if !p.IsGroupElement(msg.qa) {
-		return errors.New("Qa is an invalid group element")
+		return invalidGroupElement("SMP3", "Qa")
 	}
 
 	if !p.IsGroupElement(msg.ra) {
-		return errors.New("Ra is an invalid group element")
-	}
-
-	if !verifyZKP3(msg.cp, p.s2.g2, p.s2.g3, msg.d5, msg.d6, msg.pa, msg.qa, 6) {
-		return errors.New("cP is not a valid zero knowledge proof")
+		return invalidGroupElement("SMP3", "Ra")
 	}
 
-	qaqb := divMod(msg.qa, p.s2.qb, P)
-
-	if !verifyZKP4(msg.cr, p.s2.g3a, msg.d7, qaqb, msg.ra, 7) {
-		return errors.New("cR is not a valid zero knowledge proof")
+	qaqb := div(p.group, msg.qa, p.s2.qb)
+
+	switch verifyProofs(
+		func() bool {
+			return verifyZKP3(p.group, p.proofHash, msg.cp, p.s2.g2, p.s2.g3, msg.d5, msg.d6, msg.pa, msg.qa, 6)
+		},
+		func() bool { return verifyZKP4(p.group, p.proofHash, msg.cr, p.s2.g3a, msg.d7, qaqb, msg.ra, 7) },
+	) {
+	case 0:
+		return invalidProof("SMP3", "cP")
+	case 1:
+		return invalidProof("SMP3", "cR")
 	}
 
 	return nil
 }
 
-func (p Protocol) verifySMP3ProtocolSuccess(msg SMP3) error {
+func (p *Protocol) verifySMP3ProtocolSuccess(msg SMP3) error {
 	s2 := p.s2
 
-	papb := divMod(msg.pa, s2.pb, P)
-	rab := modExp(msg.ra, s2.b3)
+	papb := div(p.group, msg.pa, s2.pb)
+	rab := expSecret(p.group, msg.ra, s2.b3)
 
-	if !eq(rab, papb) {
-		return errors.New("protocol failed: x != y")
+	if !ctEq(rab, papb) {
+		return ErrSecretMismatch
 	}
 
 	return nil
@@ -1,23 +1,24 @@
 package smp
 
-import (
-	"errors"
-	"math/big"
-)
+import "math/big"
 
 type smp4State struct {
 	y  *big.Int
 	r7 *big.Int
 }
 
-func (s *smp4State) message(s2 *smp2State, msg3 SMP3) SMP4 {
+func (s *smp4State) values() []*big.Int {
+	return []*big.Int{s.y, s.r7}
+}
+
+func (s *smp4State) message(g Group, h proofHash, s2 *smp2State, msg3 SMP3) SMP4 {
 	var m SMP4
 
-	qaqb := divMod(msg3.qa, s2.qb, P)
+	qaqb := div(g, msg3.qa, s2.qb)
 
-	m.rb = modExp(qaqb, s2.b3)
-	m.cr = hashMPIsBN(nil, 8, modExp(G1, s.r7), modExp(qaqb, s.r7))
-	m.d7 = subMod(s.r7, mul(s2.b3, m.cr), Q)
+	m.rb = expSecret(g, qaqb, s2.b3)
+	m.cr = hashElements(g, h, 8, expSecret(g, g.Generator(), s.r7), expSecret(g, qaqb, s.r7))
+	m.d7 = subMod(s.r7, mul(s2.b3, m.cr), g.Order())
 
 	return m
 }
@@ -53,18 +54,17 @@ func (m SMP4) MPIs() []*big.Int {
 
 func (p *Protocol) newSMP4Message(m3 SMP3) (m SMP4, err error) {
 	if p.s4, err = p.newSMP4State(); err != nil {
-		p.event(Failure)
 		return
 	}
 
-	m = p.s4.message(p.s2, m3)
+	m = p.s4.message(p.group, p.proofHash, p.s2, m3)
 
 	return
 }
 
-func (p Protocol) newSMP4State() (s *smp4State, err error) {
+func (p *Protocol) newSMP4State() (s *smp4State, err error) {
 	s = &smp4State{
-		y: p.Secret,
+		y: new(big.Int).Set(p.secret),
 
 		r7: new(big.Int),
 	}
@@ -76,27 +76,27 @@ func (p Protocol) newSMP4State() (s *smp4State, err error) {
 	return
 }
 
-func (p Protocol) verifySMP4(msg SMP4) error {
+func (p *Protocol) verifySMP4(msg SMP4) error {
 	s3 := p.s3
 
 	if !p.IsGroupElement(msg.rb) {
-		return errors.New("Rb is an invalid group element")
+		return invalidGroupElement("SMP4", "Rb")
 	}
 
-	if !verifyZKP4(msg.cr, s3.g3b, msg.d7, s3.qaqb, msg.rb, 8) {
-		return errors.New("cR is not a valid zero knowledge proof")
+	if !verifyZKP4(p.group, p.proofHash, msg.cr, s3.g3b, msg.d7, s3.qaqb, msg.rb, 8) {
+		return invalidProof("SMP4", "cR")
 	}
 
 	return nil
 }
 
-func (p Protocol) verifySMP4ProtocolSuccess(msg SMP4) error {
+func (p *Protocol) verifySMP4ProtocolSuccess(msg SMP4) error {
 	s1 := p.s1
 	s3 := p.s3
 
-	rab := modExp(msg.rb, s1.a3)
-	if !eq(rab, s3.papb) {
-		return errors.New("protocol failed: x != y")
+	rab := expSecret(p.group, msg.rb, s1.a3)
+	if !ctEq(rab, s3.papb) {
+		return ErrSecretMismatch
 	}
 
 	return nil
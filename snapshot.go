@@ -0,0 +1,378 @@
+package smp
+
+import (
+	"bytes"
+	"crypto/aes"
+	"crypto/cipher"
+	"crypto/sha256"
+	"errors"
+	"io"
+	"math/big"
+)
+
+const snapshotVersion = 2
+
+// groupTagSize is the length of the identifier of the group in a snapshot
+const groupTagSize = 8
+
+var (
+	errInvalidSnapshot = errors.New("invalid snapshot")
+	errSnapshotGroup   = errors.New("the snapshot is of another group")
+
+	// snapshotAD authenticates the version of an encrypted snapshot
+	snapshotAD = []byte{'S', 'M', 'P', snapshotVersion}
+)
+
+type role byte
+
+const (
+	noRole role = iota
+	initiator
+	responder
+)
+
+const (
+	tagExpect1 byte = 1 + iota
+	tagExpect2
+	tagExpect3
+	tagExpect4
+	tagAwaitingSecret
+	tagAbortedByPeer
+)
+
+// stateValues are the number of values stored by smp1State..smp4State
+var stateValues = [4]int{4, 13, 8, 2}
+
+func stateTag(s smpState) (byte, role, bool) {
+	switch s.(type) {
+	case smpStateExpect1:
+		return tagExpect1, noRole, true
+	case smpStateAbortedByPeer:
+		return tagAbortedByPeer, noRole, true
+	case smpStateExpect2:
+		return tagExpect2, initiator, true
+	case smpStateExpect3:
+		return tagExpect3, responder, true
+	case smpStateExpect4:
+		return tagExpect4, initiator, true
+	case smpStateAwaitingSecret:
+		return tagAwaitingSecret, responder, true
+	}
+
+	return 0, noRole, false
+}
+
+func stateFromTag(t byte) (smpState, role, bool) {
+	switch t {
+	case tagExpect1:
+		return smpStateExpect1{}, noRole, true
+	case tagExpect2:
+		return smpStateExpect2{}, initiator, true
+	case tagExpect3:
+		return smpStateExpect3{}, responder, true
+	case tagExpect4:
+		return smpStateExpect4{}, initiator, true
+	case tagAwaitingSecret:
+		return smpStateAwaitingSecret{}, responder, true
+	case tagAbortedByPeer:
+		return smpStateAbortedByPeer{}, noRole, true
+	}
+
+	return nil, noRole, false
+}
+
+// groupTag identifies the group g in a snapshot: it is a hash of the encoding
+// of its generator and of its order
+func groupTag(g Group) []byte {
+	h := sha256.New()
+	h.Write(g.Encode(g.Generator()))
+	h.Write(g.Order().Bytes())
+
+	return h.Sum(nil)[:groupTagSize]
+}
+
+// MarshalBinary returns a snapshot of the protocol, with its state, role,
+// question, secret, every intermediate value and the SMP1 waiting for Respond, so it can be resumed with
+// UnmarshalBinary after a restart or in another process.
+// The snapshot holds the secrets in the clear, see MarshalEncrypted.
+func (p *Protocol) MarshalBinary() ([]byte, error) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	return p.marshalBinary()
+}
+
+func (p *Protocol) marshalBinary() ([]byte, error) {
+	tag, r, ok := stateTag(p.smpState)
+	if !ok {
+		return nil, errors.New("can't snapshot the current state")
+	}
+
+	data := []byte{snapshotVersion, tag, byte(r)}
+	data = append(data, groupTag(p.group)...)
+	data = appendData(data, []byte(p.Question))
+	// the secret of the exchange in progress, or the one to start with
+	secret := p.secret
+	if secret == nil {
+		secret = p.Secret
+	}
+	data = appendOptionalMPIs(data, secret)
+
+	states := make([][]*big.Int, 4)
+	if p.s1 != nil {
+		states[0] = p.s1.values()
+	}
+	if p.s2 != nil {
+		states[1] = p.s2.values()
+	}
+	if p.s3 != nil {
+		states[2] = p.s3.values()
+	}
+	if p.s4 != nil {
+		states[3] = p.s4.values()
+	}
+
+	for _, values := range states {
+		data = appendOptionalMPIs(data, values...)
+	}
+
+	if p.pending == nil {
+		return appendOptionalMPIs(data), nil
+	}
+
+	data = appendOptionalMPIs(data, p.pending.MPIs()...)
+	return appendData(data, []byte(p.pending.question)), nil
+}
+
+// UnmarshalBinary resumes the protocol from a snapshot created by
+// MarshalBinary. The Protocol must have been created with Options of the same
+// group, or the snapshot is rejected. The values held by the Protocol before
+// are wiped.
+func (p *Protocol) UnmarshalBinary(data []byte) error {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	p.stopWaiting()
+	if len(data) < 3+groupTagSize || data[0] != snapshotVersion {
+		return errInvalidSnapshot
+	}
+
+	state, r, ok := stateFromTag(data[1])
+	if !ok || role(data[2]) != r {
+		return errInvalidSnapshot
+	}
+
+	if !bytes.Equal(data[3:3+groupTagSize], groupTag(p.group)) {
+		return errSnapshotGroup
+	}
+
+	d, question, ok := extractData(data[3+groupTagSize:])
+	if !ok {
+		return errInvalidSnapshot
+	}
+
+	var secret []*big.Int
+	if d, secret, ok = extractOptionalMPIs(d, 1); !ok {
+		return errInvalidSnapshot
+	}
+
+	states := make([][]*big.Int, 4)
+	for i := range states {
+		if d, states[i], ok = extractOptionalMPIs(d, stateValues[i]); !ok {
+			return errInvalidSnapshot
+		}
+	}
+
+	var pending []*big.Int
+	if d, pending, ok = extractOptionalMPIs(d, len(SMP1{}.MPIs())); !ok {
+		return errInvalidSnapshot
+	}
+
+	var pendingQuestion []byte
+	if pending != nil {
+		if d, pendingQuestion, ok = extractData(d); !ok {
+			return errInvalidSnapshot
+		}
+	}
+
+	if len(d) != 0 {
+		return errInvalidSnapshot
+	}
+
+	s1, s2, s3, s4 := states[0], states[1], states[2], states[3]
+	_, awaiting := state.(smpStateAwaitingSecret)
+	if awaiting != (pending != nil) {
+		return errInvalidSnapshot
+	}
+
+	switch state.(type) {
+	case smpStateExpect2:
+		ok = s1 != nil
+	case smpStateExpect3:
+		ok = s2 != nil
+	case smpStateExpect4:
+		ok = s1 != nil && s3 != nil
+	}
+
+	if !ok {
+		return errInvalidSnapshot
+	}
+
+	p.wipeStates()
+	wipe(p.secret)
+
+	p.smpState = state
+	p.Question = string(question)
+	p.Secret, p.secret = nil, nil
+	if secret != nil {
+		p.Secret = secret[0]
+	}
+
+	switch state.(type) {
+	case smpStateExpect2, smpStateExpect3, smpStateExpect4:
+		if secret != nil {
+			p.setSecret(secret[0])
+		}
+	}
+
+	p.pending = nil
+	if pending != nil {
+		m, _ := NewSMP1Q(string(pendingQuestion), pending...)
+		p.pending = m
+	}
+
+	p.s1, p.s2, p.s3, p.s4 = nil, nil, nil, nil
+	if s1 != nil {
+		p.s1 = &smp1State{
+			a2: s1[0], a3: s1[1],
+			r2: s1[2], r3: s1[3],
+		}
+	}
+
+	if s2 != nil {
+		p.s2 = &smp2State{
+			y:  s2[0],
+			b2: s2[1], b3: s2[2],
+			r2: s2[3], r3: s2[4], r4: s2[5], r5: s2[6], r6: s2[7],
+			g2: s2[8], g3: s2[9],
+			g3a: s2[10],
+			pb:  s2[11], qb: s2[12],
+		}
+	}
+
+	if s3 != nil {
+		p.s3 = &smp3State{
+			x:   s3[0],
+			g3b: s3[1],
+			r4:  s3[2], r5: s3[3], r6: s3[4], r7: s3[5],
+			qaqb: s3[6], papb: s3[7],
+		}
+	}
+
+	if s4 != nil {
+		p.s4 = &smp4State{
+			y:  s4[0],
+			r7: s4[1],
+		}
+	}
+
+	return nil
+}
+
+// MarshalEncrypted returns a snapshot like MarshalBinary, encrypted and
+// authenticated with AES-GCM under key, which must be 16, 24 or 32 bytes long
+func (p *Protocol) MarshalEncrypted(key []byte) ([]byte, error) {
+	aead, err := snapshotAEAD(key)
+	if err != nil {
+		return nil, err
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	plaintext, err := p.marshalBinary()
+	if err != nil {
+		return nil, err
+	}
+	defer wipeBytes(plaintext)
+
+	nonce := make([]byte, aead.NonceSize())
+	if _, err := io.ReadFull(p.Rand, nonce); err != nil {
+		return nil, ErrRandomnessFailure
+	}
+
+	return aead.Seal(nonce, nonce, plaintext, snapshotAD), nil
+}
+
+// UnmarshalEncrypted resumes the protocol from a snapshot created by
+// MarshalEncrypted with the same key
+func (p *Protocol) UnmarshalEncrypted(key, data []byte) error {
+	aead, err := snapshotAEAD(key)
+	if err != nil {
+		return err
+	}
+
+	if len(data) < aead.NonceSize() {
+		return errInvalidSnapshot
+	}
+
+	nonce, ciphertext := data[:aead.NonceSize()], data[aead.NonceSize():]
+	plaintext, err := aead.Open(nil, nonce, ciphertext, snapshotAD)
+	if err != nil {
+		return errInvalidSnapshot
+	}
+	defer wipeBytes(plaintext)
+
+	return p.UnmarshalBinary(plaintext)
+}
+
+func snapshotAEAD(key []byte) (cipher.AEAD, error) {
+	block, err := aes.NewCipher(key)
+	if err != nil {
+		return nil, err
+	}
+
+	return cipher.NewGCM(block)
+}
+
+// appendOptionalMPIs appends a presence byte followed by the MPIs, or only an
+// absent marker if the first MPI is nil
+func appendOptionalMPIs(l []byte, mpis ...*big.Int) []byte {
+	if len(mpis) == 0 || mpis[0] == nil {
+		return append(l, 0)
+	}
+
+	l = append(l, 1)
+	for _, mpi := range mpis {
+		l = appendMPI(l, mpi)
+	}
+
+	return l
+}
+
+func extractOptionalMPIs(d []byte, n int) ([]byte, []*big.Int, bool) {
+	if len(d) < 1 || d[0] > 1 {
+		return nil, nil, false
+	}
+
+	present, d := d[0] == 1, d[1:]
+	if !present {
+		return d, nil, true
+	}
+
+	mpis := make([]*big.Int, n)
+	for i := range mpis {
+		var ok bool
+		if d, mpis[i], ok = extractMPI(d); !ok {
+			return nil, nil, false
+		}
+	}
+
+	return d, mpis, true
+}
+
+func wipeBytes(b []byte) {
+	for i := range b {
+		b[i] = 0
+	}
+}
@@ -0,0 +1,179 @@
+package smp
+
+import (
+	"bytes"
+	"math/big"
+	"testing"
+)
+
+// resume moves p into a new Protocol through a snapshot
+func resume(t *testing.T, p *Protocol, key []byte) *Protocol {
+	var data []byte
+	var err error
+
+	if key == nil {
+		data, err = p.MarshalBinary()
+	} else {
+		data, err = p.MarshalEncrypted(key)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewProtocol(DefaultOptions())
+	if key == nil {
+		err = r.UnmarshalBinary(data)
+	} else {
+		err = r.UnmarshalEncrypted(key, data)
+	}
+
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	return r
+}
+
+func TestProtocolResumesFromSnapshots(t *testing.T) {
+	for _, key := range [][]byte{nil, bytes.Repeat([]byte{7}, 32)} {
+		alice := NewProtocol(DefaultOptions())
+		alice.Secret = big.NewInt(42)
+		bob := NewProtocol(DefaultOptions())
+		bob.Secret = big.NewInt(42)
+
+		m, _ := alice.Compare()
+		alice = resume(t, alice, key)
+
+		m, _ = bob.Receive(m)
+		bob = resume(t, bob, key)
+
+		m, _ = alice.Receive(m)
+		alice = resume(t, alice, key)
+
+		m, _ = bob.Receive(m)
+		if _, ok := m.(SMP4); !ok {
+			t.Fatalf("expected SMP4, got %T", m)
+		}
+
+		var succeeded bool
+		alice.EventHandler = EventHandlerFunc(func(e EventDetail) {
+			succeeded = e == Succeeded{}
+		})
+
+		if _, err := alice.Receive(m); err != nil || !succeeded {
+			t.Errorf("expected the resumed protocol to succeed: %v", err)
+		}
+	}
+}
+
+func TestSnapshotsAreAuthenticated(t *testing.T) {
+	p := NewProtocol(DefaultOptions())
+	p.Secret = big.NewInt(42)
+	p.Compare()
+
+	data, err := p.MarshalEncrypted(bytes.Repeat([]byte{7}, 16))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	r := NewProtocol(DefaultOptions())
+	if err := r.UnmarshalEncrypted(bytes.Repeat([]byte{8}, 16), data); err != errInvalidSnapshot {
+		t.Errorf("expected an invalid snapshot, got %v", err)
+	}
+
+	plain, _ := p.MarshalBinary()
+	if err := r.UnmarshalBinary(plain[:len(plain)-1]); err != errInvalidSnapshot {
+		t.Errorf("expected an invalid snapshot, got %v", err)
+	}
+
+	// expecting SMP2 without the initiator state
+	empty := append([]byte{snapshotVersion, tagExpect2, byte(initiator)}, groupTag(MODP1536)...)
+	empty = append(empty, 0, 0, 0, 0, 0, 0, 0, 0, 0)
+	if err := r.UnmarshalBinary(empty); err != errInvalidSnapshot {
+		t.Errorf("expected an invalid snapshot, got %v", err)
+	}
+}
+
+func TestProtocolResumesWhileAwaitingTheSecret(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+
+	m, _ := alice.Compare()
+	bob.Receive(SMP1Q{SMP1: m.(SMP1), question: "where?"})
+	bob = resume(t, bob, nil)
+
+	if q, ok := bob.PendingQuestion(); !ok || q != "where?" {
+		t.Fatalf("expected the pending question to be resumed, got %q %v", q, ok)
+	}
+
+	m, err := bob.Respond(big.NewInt(42))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	m, _ = alice.Receive(m)
+	if m, _ = bob.Receive(m); m == (SMPAbort{}) {
+		t.Errorf("expected the resumed protocol to continue")
+	}
+}
+
+// otherGroupOptions selects MODP1536 with another generator
+type otherGroupOptions struct {
+	Options
+}
+
+func (otherGroupOptions) Group() Group {
+	return NewMODPGroup(P, Q, big.NewInt(4))
+}
+
+func TestSnapshotsOfAnotherGroupAreRejected(t *testing.T) {
+	p := NewProtocol(DefaultOptions())
+	p.Secret = big.NewInt(42)
+	p.Compare()
+	data, _ := p.MarshalBinary()
+
+	r := NewProtocol(otherGroupOptions{DefaultOptions()})
+	if err := r.UnmarshalBinary(data); err != errSnapshotGroup {
+		t.Errorf("expected %v, got %v", errSnapshotGroup, err)
+	}
+}
+
+func TestSnapshotsKeepTheAbortByPeer(t *testing.T) {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+
+	m, _ := alice.Compare()
+	bob.Receive(m)
+	bob.Receive(SMPAbort{})
+	bob = resume(t, bob, nil)
+
+	if _, ok := bob.smpState.(smpStateAbortedByPeer); !ok {
+		t.Errorf("expected the aborted by peer state to be resumed, got %T", bob.smpState)
+	}
+}
+
+func TestResumingWipesThePreviousValues(t *testing.T) {
+	p := NewProtocol(DefaultOptions())
+	p.Secret = big.NewInt(42)
+	p.Compare()
+	data, _ := p.MarshalBinary()
+
+	r := NewProtocol(DefaultOptions())
+	r.Secret = big.NewInt(42)
+	r.Compare()
+	values := append(r.s1.values(), r.secret)
+
+	if err := r.UnmarshalBinary(data); err != nil {
+		t.Fatal(err)
+	}
+
+	for i, v := range values {
+		if !isWiped(v) {
+			t.Errorf("value #%d was not wiped", i)
+		}
+	}
+}
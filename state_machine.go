@@ -1,7 +1,9 @@
 package smp
 
+import "errors"
+
 type smpState interface {
-	receiveMessage1(*Protocol, SMP1) (smpState, Message, error)
+	receiveMessage1(*Protocol, SMP1Q) (smpState, Message, error)
 	receiveMessage2(*Protocol, SMP2) (smpState, Message, error)
 	receiveMessage3(*Protocol, SMP3) (smpState, Message, error)
 	receiveMessage4(*Protocol, SMP4) (smpState, Message, error)
@@ -13,110 +15,194 @@ type smpStateExpect2 struct{ smpStateBase }
 type smpStateExpect3 struct{ smpStateBase }
 type smpStateExpect4 struct{ smpStateBase }
 
-func abortState(e error) (smpState, Message, error) {
-	//TODO: should wipe all the intermediate s1..s4 values
+// smpStateAbortedByPeer is smpStateExpect1 right after the peer aborted, so
+// an SMP1 received in this state restarts the protocol
+type smpStateAbortedByPeer struct{ smpStateExpect1 }
+
+// smpStateAwaitingSecret means we received a valid SMP1 (stored in
+// Protocol.pending) and wait for Respond to be called with our secret
+type smpStateAwaitingSecret struct{ smpStateBase }
+
+// stateName returns the name of the state s, as in the spec
+func stateName(s smpState) string {
+	switch s.(type) {
+	case smpStateExpect2:
+		return "SMPSTATE_EXPECT2"
+	case smpStateExpect3:
+		return "SMPSTATE_EXPECT3"
+	case smpStateExpect4:
+		return "SMPSTATE_EXPECT4"
+	}
+
+	return "SMPSTATE_EXPECT1"
+}
+
+// isIdle tells whether no exchange is in progress in the state s
+func isIdle(s smpState) bool {
+	switch s.(type) {
+	case smpStateExpect1, smpStateAbortedByPeer:
+		return true
+	}
+
+	return false
+}
+
+// abortState wipes the protocol and returns the SMPAbort addressed to the
+// peer along with the reason e, if any. The caller's Secret is kept if no
+// exchange was in progress, since it may be meant for the next one.
+func abortState(p *Protocol, e error) (smpState, Message, error) {
+	if isIdle(p.smpState) {
+		p.wipeSecret()
+	} else {
+		p.wipe()
+	}
+
 	return smpStateExpect1{}, SMPAbort{}, e
 }
 
-func sendSMPAbortAndRestartStateMachine() (smpState, Message, error) {
-	//must return nil error otherwise the abort message will be ignored
-	return abortState(nil)
+func sendSMPAbortAndRestartStateMachine(p *Protocol) (smpState, Message, error) {
+	return abortState(p, nil)
 }
 
-func abortStateMachineAndNotifyCheated(p *Protocol) (smpState, Message, error) {
-	p.event(Cheated)
-	return sendSMPAbortAndRestartStateMachine()
+func abortStateMachineAndNotifyCheated(p *Protocol, err error) (smpState, Message, error) {
+	d := CheatDetected{Reason: err.Error(), Err: err}
+
+	var ge *InvalidGroupElementError
+	var pe *InvalidProofError
+	switch {
+	case errors.As(err, &ge):
+		d.Field, d.Reason = ge.Field, reasonInvalidGroupElement
+	case errors.As(err, &pe):
+		d.Field, d.Reason = pe.Proof, reasonInvalidProof
+	}
+
+	p.event(d)
+	return abortState(p, err)
 }
 
-func abortStateMachineAndNotifyError(p *Protocol) (smpState, Message, error) {
-	p.event(Error)
-	return sendSMPAbortAndRestartStateMachine()
+func abortStateMachineAndNotifyError(p *Protocol, got string) (smpState, Message, error) {
+	err := &UnexpectedMessageError{State: stateName(p.smpState), Got: got}
+	p.event(ProtocolError{Reason: err.Error(), Err: err})
+	return abortState(p, err)
 }
 
-func (smpStateBase) receiveMessage1(p *Protocol, m SMP1) (smpState, Message, error) {
-	return abortStateMachineAndNotifyError(p)
+func abortStateMachineAndNotifyFailure(p *Protocol, err error) (smpState, Message, error) {
+	p.event(Failed{Err: err})
+	return abortState(p, err)
+}
+
+func (smpStateBase) receiveMessage1(p *Protocol, m SMP1Q) (smpState, Message, error) {
+	return abortStateMachineAndNotifyError(p, "SMP1")
 }
 
 func (smpStateBase) receiveMessage2(p *Protocol, m SMP2) (smpState, Message, error) {
-	return abortStateMachineAndNotifyError(p)
+	return abortStateMachineAndNotifyError(p, "SMP2")
 }
 
 func (smpStateBase) receiveMessage3(p *Protocol, m SMP3) (smpState, Message, error) {
-	return abortStateMachineAndNotifyError(p)
+	return abortStateMachineAndNotifyError(p, "SMP3")
 }
 
 func (smpStateBase) receiveMessage4(p *Protocol, m SMP4) (smpState, Message, error) {
-	return abortStateMachineAndNotifyError(p)
+	return abortStateMachineAndNotifyError(p, "SMP4")
 }
 
-func (smpStateExpect1) receiveMessage1(p *Protocol, m SMP1) (smpState, Message, error) {
-	err := p.verifySMP1(m)
+func (smpStateExpect1) receiveMessage1(p *Protocol, m SMP1Q) (smpState, Message, error) {
+	err := p.verifySMP1(m.SMP1)
 	if err != nil {
-		return abortStateMachineAndNotifyCheated(p)
+		return abortStateMachineAndNotifyCheated(p, err)
+	}
+
+	if p.Secret == nil {
+		p.pending = &m
+		p.event(AskForSecret{Question: m.question})
+		return smpStateAwaitingSecret{}, nil, nil
 	}
 
+	p.setSecret(p.Secret)
+	return respondSMP1(p, m.SMP1)
+}
+
+// receiveMessage1 replaces the pending SMP1 when the peer restarts the
+// protocol before we respond
+func (smpStateAwaitingSecret) receiveMessage1(p *Protocol, m SMP1Q) (smpState, Message, error) {
+	p.pending = nil
+	return smpStateExpect1{}.receiveMessage1(p, m)
+}
+
+func (smpStateAbortedByPeer) receiveMessage1(p *Protocol, m SMP1Q) (smpState, Message, error) {
+	p.event(Restarted{ByPeer: true})
+	return smpStateExpect1{}.receiveMessage1(p, m)
+}
+
+func respondSMP1(p *Protocol, m SMP1) (smpState, Message, error) {
 	m2, err := p.newSMP2Message(m)
 	if err != nil {
-		return abortStateMachineAndNotifyCheated(p)
+		return abortStateMachineAndNotifyFailure(p, err)
 	}
 
-	p.event(InProgress)
+	p.event(Progress{Percent: 50, Step: 2})
 	return smpStateExpect3{}, m2, nil
 }
 
 func (smpStateExpect2) receiveMessage2(p *Protocol, m SMP2) (smpState, Message, error) {
 	err := p.verifySMP2(m)
 	if err != nil {
-		return abortStateMachineAndNotifyCheated(p)
+		return abortStateMachineAndNotifyCheated(p, err)
 	}
 
 	m3, err := p.newSMP3Message(m)
 	if err != nil {
-		return abortStateMachineAndNotifyCheated(p)
+		return abortStateMachineAndNotifyFailure(p, err)
 	}
 
-	p.event(InProgress)
+	p.event(Progress{Percent: 75, Step: 3})
 	return smpStateExpect4{}, m3, nil
 }
 
 func (smpStateExpect3) receiveMessage3(p *Protocol, m SMP3) (smpState, Message, error) {
 	err := p.verifySMP3(m)
 	if err != nil {
-		return abortStateMachineAndNotifyCheated(p)
+		return abortStateMachineAndNotifyCheated(p, err)
 	}
 
 	err = p.verifySMP3ProtocolSuccess(m)
 	if err != nil {
-		p.event(Failure)
-		return sendSMPAbortAndRestartStateMachine()
+		return abortStateMachineAndNotifyFailure(p, err)
 	}
 
 	msg, err := p.newSMP4Message(m)
 	if err != nil {
-		return abortStateMachineAndNotifyCheated(p)
+		return abortStateMachineAndNotifyFailure(p, err)
 	}
 
-	p.event(Success)
+	p.wipe()
+	p.event(Succeeded{})
 	return smpStateExpect1{}, msg, nil
 }
 
 func (smpStateExpect4) receiveMessage4(p *Protocol, m SMP4) (smpState, Message, error) {
 	err := p.verifySMP4(m)
 	if err != nil {
-		return abortStateMachineAndNotifyCheated(p)
+		return abortStateMachineAndNotifyCheated(p, err)
 	}
 
 	err = p.verifySMP4ProtocolSuccess(m)
 	if err != nil {
-		p.event(Failure)
-		return sendSMPAbortAndRestartStateMachine()
+		return abortStateMachineAndNotifyFailure(p, err)
 	}
 
-	p.event(Success)
+	p.wipe()
+	p.event(Succeeded{})
 	return smpStateExpect1{}, nil, nil
 }
 
 func (m SMP1) received(p *Protocol) (ret Message, err error) {
+	p.smpState, ret, err = p.smpState.receiveMessage1(p, SMP1Q{SMP1: m})
+	return
+}
+
+func (m SMP1Q) received(p *Protocol) (ret Message, err error) {
 	p.smpState, ret, err = p.smpState.receiveMessage1(p, m)
 	return
 }
@@ -136,8 +222,15 @@ func (m SMP4) received(p *Protocol) (ret Message, err error) {
 	return
 }
 
+// received moves to smpStateAbortedByPeer only if an exchange was in
+// progress, so the next SMP1 after a stale abort is not reported as a
+// restart. The caller's Secret is kept for the SMP1 of a restart.
 func (m SMPAbort) received(p *Protocol) (ret Message, err error) {
-	p.smpState = smpStateExpect1{}
-	p.event(Abort)
+	p.wipeSecret()
+	if !isIdle(p.smpState) {
+		p.smpState = smpStateAbortedByPeer{}
+	}
+
+	p.event(Aborted{ByPeer: true})
 	return
 }
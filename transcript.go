@@ -0,0 +1,221 @@
+package smp
+
+import (
+	"encoding/json"
+	"errors"
+	"math/big"
+	"sync"
+	"time"
+)
+
+// Direction tells whether a message was sent or received
+type Direction string
+
+const (
+	// Sent is a message addressed to the peer
+	Sent Direction = "sent"
+	// Received is a message received from the peer
+	Received Direction = "received"
+)
+
+// TranscriptEntry is a message sent or received by a Protocol.
+// It holds only public values: the secrets are never recorded. In JSON, the
+// MPIs are base64url strings, as in the JSON encoding of the messages.
+type TranscriptEntry struct {
+	Direction   Direction  `json:"direction"`
+	Type        string     `json:"type"`
+	Question    string     `json:"question,omitempty"`
+	MPIs        []*big.Int `json:"-"`
+	StateBefore string     `json:"state_before"`
+	StateAfter  string     `json:"state_after"`
+	Time        time.Time  `json:"time"`
+}
+
+// jsonTranscriptEntry is the JSON encoding of a TranscriptEntry: the MPIs
+// are encoded like in the messages, with jsonMPI
+type jsonTranscriptEntry struct {
+	jsonEntry
+	MPIs []string `json:"mpis"`
+}
+
+type jsonEntry TranscriptEntry
+
+// MarshalJSON encodes the entry, with each MPI as a base64url string
+func (e TranscriptEntry) MarshalJSON() ([]byte, error) {
+	j := jsonTranscriptEntry{jsonEntry: jsonEntry(e), MPIs: []string{}}
+	for _, mpi := range e.MPIs {
+		if mpi == nil {
+			return nil, errMissingMPI
+		}
+
+		j.MPIs = append(j.MPIs, jsonMPI.EncodeToString(mpi.Bytes()))
+	}
+
+	return json.Marshal(j)
+}
+
+// UnmarshalJSON decodes an entry encoded by MarshalJSON
+func (e *TranscriptEntry) UnmarshalJSON(data []byte) error {
+	var j jsonTranscriptEntry
+	if err := json.Unmarshal(data, &j); err != nil {
+		return err
+	}
+
+	*e = TranscriptEntry(j.jsonEntry)
+	e.MPIs = nil
+	for _, s := range j.MPIs {
+		b, err := jsonMPI.DecodeString(s)
+		if err != nil || len(b) > 0 && b[0] == 0 {
+			return errors.New("an MPI is not in canonical base64url")
+		}
+
+		e.MPIs = append(e.MPIs, new(big.Int).SetBytes(b))
+	}
+
+	return nil
+}
+
+// Message returns the message recorded in the entry
+func (e TranscriptEntry) Message() (Message, error) {
+	switch e.Type {
+	case "SMP1":
+		return NewSMP1(e.MPIs...)
+	case "SMP1Q":
+		return NewSMP1Q(e.Question, e.MPIs...)
+	case "SMP2":
+		return NewSMP2(e.MPIs...)
+	case "SMP3":
+		return NewSMP3(e.MPIs...)
+	case "SMP4":
+		return NewSMP4(e.MPIs...)
+	case "SMPAbort":
+		if len(e.MPIs) != 0 {
+			return nil, errors.New("SMPAbort has no MPIs")
+		}
+
+		return SMPAbort{}, nil
+	}
+
+	return nil, errors.New("unknown message type " + e.Type)
+}
+
+// Transcript records every message sent and received by the Protocol it is
+// attached to. It can be exported to JSON and reloaded as a fixture to replay
+// a run. It is safe for concurrent use.
+type Transcript struct {
+	mu      sync.Mutex
+	entries []TranscriptEntry
+}
+
+// Entries returns the recorded entries, in order
+func (t *Transcript) Entries() []TranscriptEntry {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	return append([]TranscriptEntry(nil), t.entries...)
+}
+
+// Messages returns the recorded messages in the given direction, in order.
+// Feeding the received messages to a Protocol seeded like the original one
+// (see NewHMACDRBG) replays the run.
+func (t *Transcript) Messages(d Direction) ([]Message, error) {
+	var ret []Message
+	for _, e := range t.Entries() {
+		if e.Direction != d {
+			continue
+		}
+
+		m, err := e.Message()
+		if err != nil {
+			return nil, err
+		}
+
+		ret = append(ret, m)
+	}
+
+	return ret, nil
+}
+
+// MarshalJSON exports the entries as a JSON array
+func (t *Transcript) MarshalJSON() ([]byte, error) {
+	return json.Marshal(t.Entries())
+}
+
+// UnmarshalJSON reloads the entries exported by MarshalJSON
+func (t *Transcript) UnmarshalJSON(data []byte) error {
+	var entries []TranscriptEntry
+	if err := json.Unmarshal(data, &entries); err != nil {
+		return err
+	}
+
+	for _, e := range entries {
+		if _, err := e.Message(); err != nil {
+			return err
+		}
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.entries = entries
+	return nil
+}
+
+func (t *Transcript) record(d Direction, m Message, before, after smpState) {
+	e := TranscriptEntry{
+		Direction:   d,
+		Type:        messageType(m),
+		StateBefore: stateName(before),
+		StateAfter:  stateName(after),
+		Time:        time.Now(),
+	}
+
+	for _, mpi := range m.MPIs() {
+		e.MPIs = append(e.MPIs, new(big.Int).Set(mpi))
+	}
+
+	switch q := m.(type) {
+	case SMP1Q:
+		e.Question = q.question
+	case *SMP1Q:
+		e.Question = q.question
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.entries = append(t.entries, e)
+}
+
+// record adds the messages to the transcript of the protocol, if any
+func (p *Protocol) record(d Direction, before smpState, msgs ...Message) {
+	if p.Transcript == nil {
+		return
+	}
+
+	for _, m := range msgs {
+		if m != nil {
+			p.Transcript.record(d, m, before, p.smpState)
+		}
+	}
+}
+
+// messageType returns the name of the message, as in the spec
+func messageType(m Message) string {
+	switch m.(type) {
+	case SMP1, *SMP1:
+		return "SMP1"
+	case SMP1Q, *SMP1Q:
+		return "SMP1Q"
+	case SMP2, *SMP2:
+		return "SMP2"
+	case SMP3, *SMP3:
+		return "SMP3"
+	case SMP4, *SMP4:
+		return "SMP4"
+	case SMPAbort, *SMPAbort:
+		return "SMPAbort"
+	}
+
+	return "unknown"
+}
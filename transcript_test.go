@@ -0,0 +1,131 @@
+package smp
+
+import (
+	"encoding/json"
+	"math/big"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestTranscriptRecordsAndReplaysARun(t *testing.T) {
+	secret, _ := new(big.Int).SetString("123456789123456789123456789", 10)
+
+	newAlice := func() *Protocol {
+		p := NewProtocol(DefaultOptions())
+		p.Rand = NewHMACDRBG([]byte("alice"), nil)
+		p.Secret = new(big.Int).Set(secret)
+		p.Question = "where?"
+		p.Transcript = &Transcript{}
+		return p
+	}
+
+	alice := newAlice()
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = new(big.Int).Set(secret)
+
+	m, _ := alice.Compare()
+	m, _ = bob.Receive(m)
+	m, _ = alice.Receive(m)
+	m, _ = bob.Receive(m)
+	alice.Receive(m)
+
+	data, err := json.Marshal(alice.Transcript)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if strings.Contains(string(data), secret.String()) {
+		t.Errorf("the transcript should not contain the secret")
+	}
+
+	fixture := &Transcript{}
+	if err := json.Unmarshal(data, fixture); err != nil {
+		t.Fatal(err)
+	}
+
+	var got []string
+	for _, e := range fixture.Entries() {
+		got = append(got, string(e.Direction)+" "+e.Type+" "+e.StateBefore+" -> "+e.StateAfter)
+		if e.Time.IsZero() {
+			t.Errorf("missing timestamp")
+		}
+	}
+
+	expected := []string{
+		"sent SMP1Q SMPSTATE_EXPECT1 -> SMPSTATE_EXPECT2",
+		"received SMP2 SMPSTATE_EXPECT2 -> SMPSTATE_EXPECT4",
+		"sent SMP3 SMPSTATE_EXPECT2 -> SMPSTATE_EXPECT4",
+		"received SMP4 SMPSTATE_EXPECT4 -> SMPSTATE_EXPECT1",
+	}
+	if !reflect.DeepEqual(got, expected) {
+		t.Errorf("expected %v, got %v", expected, got)
+	}
+
+	if q := fixture.Entries()[0].Question; q != "where?" {
+		t.Errorf("expected the question to be recorded, got %q", q)
+	}
+
+	// replay alice's side of the run
+	received, _ := fixture.Messages(Received)
+	sent, _ := fixture.Messages(Sent)
+
+	replay := newAlice()
+	replay.Transcript = nil
+
+	m, _ = replay.Compare()
+	replayed := []Message{m}
+	for _, r := range received {
+		if m, _ = replay.Receive(r); m != nil {
+			replayed = append(replayed, m)
+		}
+	}
+
+	if len(replayed) != len(sent) {
+		t.Fatalf("expected %d messages, got %d", len(sent), len(replayed))
+	}
+
+	for i := range sent {
+		if !reflect.DeepEqual(sent[i].MPIs(), replayed[i].MPIs()) {
+			t.Errorf("replayed message %d differs", i)
+		}
+	}
+}
+
+func TestTranscriptRejectsUnknownMessages(t *testing.T) {
+	err := json.Unmarshal([]byte(`[{"direction":"sent","type":"SMP5","mpis":[]}]`), &Transcript{})
+	if err == nil {
+		t.Errorf("expected an error")
+	}
+}
+
+func TestTranscriptEncodesMPIsAsBase64URL(t *testing.T) {
+	e := TranscriptEntry{Direction: Sent, Type: "SMP1", MPIs: []*big.Int{
+		big.NewInt(0), big.NewInt(1), big.NewInt(0xfbff), big.NewInt(4), big.NewInt(5), big.NewInt(6),
+	}}
+
+	data, err := json.Marshal(e)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if !strings.Contains(string(data), `"mpis":["","AQ","-_8","BA","BQ","Bg"]`) {
+		t.Errorf("unexpected encoding %s", data)
+	}
+
+	var decoded TranscriptEntry
+	if err := json.Unmarshal(data, &decoded); err != nil {
+		t.Fatal(err)
+	}
+
+	if !reflect.DeepEqual(decoded.MPIs, e.MPIs) {
+		t.Errorf("expected %v, got %v", e.MPIs, decoded.MPIs)
+	}
+
+	for _, mpis := range []string{`[1]`, `["AQ=="]`, `["AAE"]`, `["+/8"]`} {
+		err := json.Unmarshal([]byte(`{"direction":"sent","type":"SMP1","mpis":`+mpis+`}`), &decoded)
+		if err == nil {
+			t.Errorf("expected an error for %s", mpis)
+		}
+	}
+}
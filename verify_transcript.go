@@ -0,0 +1,199 @@
+package smp
+
+import (
+	"fmt"
+	"math/big"
+)
+
+// ProofStatus is the result of checking a zero knowledge proof
+type ProofStatus int
+
+const (
+	// ProofValid means the proof verifies
+	ProofValid ProofStatus = iota
+	// ProofInvalid means the proof does not verify
+	ProofInvalid
+	// ProofUnverifiable means the proof can not be checked from the public
+	// values of the transcript
+	ProofUnverifiable
+)
+
+func (s ProofStatus) String() string {
+	switch s {
+	case ProofValid:
+		return "valid"
+	case ProofInvalid:
+		return "invalid"
+	case ProofUnverifiable:
+		return "unverifiable"
+	}
+
+	return "unknown"
+}
+
+// ProofCheck is the result of checking one proof of a transcript
+type ProofCheck struct {
+	// Message is the message that carries the proof, like "SMP2"
+	Message string
+	// Proof is the name of the proof, as in the spec, like "c3"
+	Proof  string
+	Status ProofStatus
+	// Reason explains why the proof is unverifiable
+	Reason string
+}
+
+// TranscriptReport is the result of VerifyTranscript
+type TranscriptReport struct {
+	// WellFormed means the messages come in the order of the protocol and
+	// every group element is valid. Problems tells what is wrong otherwise.
+	WellFormed bool
+	Problems   []string
+
+	// Checks has one entry per proof, in the order of the transcript
+	Checks []ProofCheck
+}
+
+// Valid returns whether the transcript is well formed and no proof is invalid
+func (r *TranscriptReport) Valid() bool {
+	if !r.WellFormed {
+		return false
+	}
+
+	for _, c := range r.Checks {
+		if c.Status == ProofInvalid {
+			return false
+		}
+	}
+
+	return true
+}
+
+func (r *TranscriptReport) problem(format string, args ...interface{}) {
+	r.WellFormed = false
+	r.Problems = append(r.Problems, fmt.Sprintf(format, args...))
+}
+
+func (r *TranscriptReport) check(message, proof string, ok bool) {
+	s := ProofValid
+	if !ok {
+		s = ProofInvalid
+	}
+
+	r.Checks = append(r.Checks, ProofCheck{Message: message, Proof: proof, Status: s})
+}
+
+// cP proves that Pb, Qb (or Pa, Qa) were built from g2 and g3, which are
+// Diffie-Hellman values only the participants can compute
+const reasonNeedsSharedGenerators = "needs g2 and g3, which require a2 or b2 and a3 or b3"
+
+func (r *TranscriptReport) unverifiable(message, proof, reason string) {
+	r.Checks = append(r.Checks, ProofCheck{Message: message, Proof: proof, Status: ProofUnverifiable, Reason: reason})
+}
+
+// elements checks the group elements carried by a message
+func (r *TranscriptReport) elements(p *Protocol, message string, names []string, values ...*big.Int) bool {
+	ok := true
+	for i, name := range names {
+		if !p.IsGroupElement(values[i]) {
+			r.problem("%s: %s %s", message, name, reasonInvalidGroupElement)
+			ok = false
+		}
+	}
+
+	return ok
+}
+
+// VerifyTranscript checks, without either secret, the public values of a run
+// of the protocol: the messages SMP1 (or SMP1Q) to SMP4, in order, as sent
+// between the peers. A run that ended early may omit the last messages.
+//
+// Every proof of knowledge of an exponent (c2, c3 and cR) is checked. The
+// cP proofs can't be checked by a third party and are reported as
+// ProofUnverifiable, and neither can the outcome of the comparison. Checks
+// stop at the first message that is out of order or carries an invalid group
+// element.
+func VerifyTranscript(options Options, msgs []Message) *TranscriptReport {
+	p := NewProtocol(options)
+	g, h := p.group, p.proofHash
+	r := &TranscriptReport{WellFormed: true}
+
+	if len(msgs) == 0 || len(msgs) > 4 {
+		r.problem("expected 1 to 4 messages, got %d", len(msgs))
+		return r
+	}
+
+	var m1 SMP1
+	var m2 SMP2
+	var m3 SMP3
+	for i, m := range msgs {
+		m = derefMessage(m)
+		if q, ok := m.(SMP1Q); ok {
+			m = q.SMP1
+		}
+
+		expected := fmt.Sprintf("SMP%d", i+1)
+		if t := messageType(m); t != expected {
+			r.problem("message %d is %s, expected %s", i+1, t, expected)
+			return r
+		}
+
+		switch v := m.(type) {
+		case SMP1:
+			m1 = v
+			if !r.elements(p, "SMP1", []string{"g2a", "g3a"}, v.g2a, v.g3a) {
+				return r
+			}
+
+			r.check("SMP1", "c2", verifyZKP(g, h, v.d2, v.g2a, v.c2, 1))
+			r.check("SMP1", "c3", verifyZKP(g, h, v.d3, v.g3a, v.c3, 2))
+		case SMP2:
+			m2 = v
+			if !r.elements(p, "SMP2", []string{"g2b", "g3b", "Pb", "Qb"}, v.g2b, v.g3b, v.pb, v.qb) {
+				return r
+			}
+
+			r.check("SMP2", "c2", verifyZKP(g, h, v.d2, v.g2b, v.c2, 3))
+			r.check("SMP2", "c3", verifyZKP(g, h, v.d3, v.g3b, v.c3, 4))
+			r.unverifiable("SMP2", "cP", reasonNeedsSharedGenerators)
+		case SMP3:
+			m3 = v
+			if !r.elements(p, "SMP3", []string{"Pa", "Qa", "Ra"}, v.pa, v.qa, v.ra) {
+				return r
+			}
+
+			qaqb := div(g, v.qa, m2.qb)
+			r.unverifiable("SMP3", "cP", reasonNeedsSharedGenerators)
+			r.check("SMP3", "cR", verifyZKP4(g, h, v.cr, m1.g3a, v.d7, qaqb, v.ra, 7))
+		case SMP4:
+			if !r.elements(p, "SMP4", []string{"Rb"}, v.rb) {
+				return r
+			}
+
+			qaqb := div(g, m3.qa, m2.qb)
+			r.check("SMP4", "cR", verifyZKP4(g, h, v.cr, m2.g3b, v.d7, qaqb, v.rb, 8))
+		}
+	}
+
+	return r
+}
+
+// derefMessage returns the message a pointer to a message points to, like
+// the ones returned by NewSMP1
+func derefMessage(m Message) Message {
+	switch v := m.(type) {
+	case *SMP1:
+		return *v
+	case *SMP1Q:
+		return *v
+	case *SMP2:
+		return *v
+	case *SMP3:
+		return *v
+	case *SMP4:
+		return *v
+	case *SMPAbort:
+		return *v
+	}
+
+	return m
+}
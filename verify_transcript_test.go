@@ -0,0 +1,60 @@
+package smp
+
+import (
+	"math/big"
+	"testing"
+)
+
+func TestVerifyTranscript(t *testing.T) {
+	msgs := seededRun("alice test seed", "bob test seed")
+
+	report := VerifyTranscript(DefaultOptions(), msgs)
+	if !report.WellFormed || !report.Valid() {
+		t.Fatalf("expected a valid transcript, got %+v", report)
+	}
+
+	statuses := map[string]ProofStatus{}
+	for _, c := range report.Checks {
+		statuses[c.Message+" "+c.Proof] = c.Status
+	}
+
+	expected := map[string]ProofStatus{
+		"SMP1 c2": ProofValid, "SMP1 c3": ProofValid,
+		"SMP2 c2": ProofValid, "SMP2 c3": ProofValid, "SMP2 cP": ProofUnverifiable,
+		"SMP3 cP": ProofUnverifiable, "SMP3 cR": ProofValid,
+		"SMP4 cR": ProofValid,
+	}
+	for k, v := range expected {
+		if statuses[k] != v {
+			t.Errorf("%s: expected %v, got %v", k, v, statuses[k])
+		}
+	}
+
+	// a tampered proof
+	m3 := msgs[2].(SMP3)
+	m3.d7 = new(big.Int).Add(m3.d7, big.NewInt(1))
+	report = VerifyTranscript(DefaultOptions(), []Message{msgs[0], msgs[1], m3, msgs[3]})
+	if report.Valid() {
+		t.Errorf("expected the tampered cR to be invalid")
+	}
+
+	for _, c := range report.Checks {
+		if c.Status == ProofInvalid && (c.Message != "SMP3" || c.Proof != "cR") {
+			t.Errorf("unexpected invalid proof %s %s", c.Message, c.Proof)
+		}
+	}
+
+	// messages out of order
+	report = VerifyTranscript(DefaultOptions(), []Message{msgs[1], msgs[0]})
+	if report.WellFormed || len(report.Problems) != 1 {
+		t.Errorf("expected the transcript to be malformed, got %+v", report)
+	}
+
+	// an invalid group element
+	m2 := msgs[1].(SMP2)
+	m2.qb = big.NewInt(1)
+	report = VerifyTranscript(DefaultOptions(), []Message{msgs[0], m2})
+	if report.WellFormed || len(report.Checks) != 2 {
+		t.Errorf("expected the checks to stop at an invalid Qb, got %+v", report)
+	}
+}
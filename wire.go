@@ -0,0 +1,345 @@
+package smp
+
+import (
+	"errors"
+	"math/big"
+	"strconv"
+)
+
+// wireVersion is the version of the binary encoding of messages
+const wireVersion = 1
+
+// Types of the binary encoding of messages. They are the OTR TLV types.
+const (
+	wireSMP1 byte = 2 + iota
+	wireSMP2
+	wireSMP3
+	wireSMP4
+	wireSMPAbort
+	wireSMP1Q
+)
+
+// wireMPIs is the number of MPIs of each type
+var wireMPIs = map[byte]int{
+	wireSMP1:     6,
+	wireSMP1Q:    6,
+	wireSMP2:     11,
+	wireSMP3:     8,
+	wireSMP4:     3,
+	wireSMPAbort: 0,
+}
+
+// MalformedMessageError means an encoded message could not be decoded
+type MalformedMessageError struct {
+	Reason string
+}
+
+func (e *MalformedMessageError) Error() string {
+	return "malformed message: " + e.Reason
+}
+
+func malformed(reason string) error {
+	return &MalformedMessageError{Reason: reason}
+}
+
+var errMissingMPI = errors.New("the message has no value for an MPI")
+
+// checkMPIs checks every MPI of a message to encode is set, which is not the
+// case of the zero value of a message
+func checkMPIs(mpis []*big.Int) error {
+	for _, mpi := range mpis {
+		if mpi == nil {
+			return errMissingMPI
+		}
+	}
+
+	return nil
+}
+
+// marshalMessage encodes a message as
+//
+//	version (BYTE) || type (BYTE) || [question (DATA), for SMP1Q] ||
+//	count (INT) || count MPIs
+//
+// where INT, DATA and MPI are the OTR encodings, and MPIs have no leading
+// zeros
+func marshalMessage(t byte, question string, mpis []*big.Int) ([]byte, error) {
+	if err := checkMPIs(mpis); err != nil {
+		return nil, err
+	}
+
+	data := []byte{wireVersion, t}
+	if t == wireSMP1Q {
+		data = appendData(data, []byte(question))
+	}
+
+	data = appendWord(data, uint32(len(mpis)))
+	for _, mpi := range mpis {
+		data = appendMPI(data, mpi)
+	}
+
+	return data, nil
+}
+
+// unmarshalMessage decodes a message encoded by marshalMessage, and checks
+// it is of the given type
+func unmarshalMessage(data []byte, want byte) (string, []*big.Int, error) {
+	t, question, mpis, err := decodeMessage(data)
+	if err != nil {
+		return "", nil, err
+	}
+
+	if t != want {
+		return "", nil, malformed("unexpected message type " + strconv.Itoa(int(t)))
+	}
+
+	return question, mpis, nil
+}
+
+func decodeMessage(data []byte) (byte, string, []*big.Int, error) {
+	if len(data) < 2 {
+		return 0, "", nil, malformed("missing header")
+	}
+
+	if err := checkMessageHeader(data[0], data[1]); err != nil {
+		return 0, "", nil, err
+	}
+
+	t, d := data[1], data[2:]
+
+	var question []byte
+	var ok bool
+	if t == wireSMP1Q {
+		if d, question, ok = extractData(d); !ok {
+			return 0, "", nil, malformed("truncated question")
+		}
+	}
+
+	d, count, ok := extractWord(d)
+	if !ok {
+		return 0, "", nil, malformed("missing MPI count")
+	}
+
+	if count != uint32(wireMPIs[t]) {
+		return 0, "", nil, malformed("expected " + strconv.Itoa(wireMPIs[t]) + " MPIs, got " + strconv.FormatUint(uint64(count), 10))
+	}
+
+	values := make([][]byte, count)
+	for i := range values {
+		if d, values[i], ok = extractData(d); !ok {
+			return 0, "", nil, malformed("truncated MPI")
+		}
+	}
+
+	if len(d) != 0 {
+		return 0, "", nil, malformed("trailing data")
+	}
+
+	mpis, err := messageMPIs(t, values)
+	return t, string(question), mpis, err
+}
+
+// checkMessageHeader checks the version and the type of an encoded message.
+// Every encoding shares the rules of checkMessageHeader and messageMPIs.
+func checkMessageHeader(version, t byte) error {
+	if version != wireVersion {
+		return malformed("unsupported version " + strconv.Itoa(int(version)))
+	}
+
+	if _, ok := wireMPIs[t]; !ok {
+		return malformed("unknown message type " + strconv.Itoa(int(t)))
+	}
+
+	return nil
+}
+
+// messageMPIs returns the MPIs of a message of type t from their big-endian
+// encodings, which must be canonical and as many as the type has
+func messageMPIs(t byte, values [][]byte) ([]*big.Int, error) {
+	if len(values) != wireMPIs[t] {
+		return nil, malformed("expected " + strconv.Itoa(wireMPIs[t]) + " MPIs, got " + strconv.Itoa(len(values)))
+	}
+
+	mpis := make([]*big.Int, len(values))
+	for i, b := range values {
+		if len(b) > 0 && b[0] == 0 {
+			return nil, malformed("MPI with leading zeros")
+		}
+
+		mpis[i] = new(big.Int).SetBytes(b)
+	}
+
+	return mpis, nil
+}
+
+// UnmarshalMessage decodes a message encoded by its MarshalBinary method
+func UnmarshalMessage(data []byte) (Message, error) {
+	t, question, mpis, err := decodeMessage(data)
+	if err != nil {
+		return nil, err
+	}
+
+	return newMessage(t, question, mpis)
+}
+
+// newMessage returns the message of type t with the given values
+func newMessage(t byte, question string, mpis []*big.Int) (Message, error) {
+	var m Message
+	var err error
+
+	switch t {
+	case wireSMP1:
+		var v *SMP1
+		if v, err = NewSMP1(mpis...); err == nil {
+			m = *v
+		}
+	case wireSMP1Q:
+		var v *SMP1Q
+		if v, err = NewSMP1Q(question, mpis...); err == nil {
+			m = *v
+		}
+	case wireSMP2:
+		var v *SMP2
+		if v, err = NewSMP2(mpis...); err == nil {
+			m = *v
+		}
+	case wireSMP3:
+		var v *SMP3
+		if v, err = NewSMP3(mpis...); err == nil {
+			m = *v
+		}
+	case wireSMP4:
+		var v *SMP4
+		if v, err = NewSMP4(mpis...); err == nil {
+			m = *v
+		}
+	case wireSMPAbort:
+		m = SMPAbort{}
+	default:
+		err = malformed("unknown message type " + strconv.Itoa(int(t)))
+	}
+
+	return m, err
+}
+
+// MarshalBinary encodes the message in the binary format read by
+// UnmarshalMessage
+func (m SMP1) MarshalBinary() ([]byte, error) {
+	return marshalMessage(wireSMP1, "", m.MPIs())
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary
+func (m *SMP1) UnmarshalBinary(data []byte) error {
+	_, mpis, err := unmarshalMessage(data, wireSMP1)
+	if err != nil {
+		return err
+	}
+
+	n, err := NewSMP1(mpis...)
+	if err != nil {
+		return err
+	}
+
+	*m = *n
+	return nil
+}
+
+// MarshalBinary encodes the message in the binary format read by
+// UnmarshalMessage
+func (m SMP1Q) MarshalBinary() ([]byte, error) {
+	return marshalMessage(wireSMP1Q, m.question, m.MPIs())
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary
+func (m *SMP1Q) UnmarshalBinary(data []byte) error {
+	question, mpis, err := unmarshalMessage(data, wireSMP1Q)
+	if err != nil {
+		return err
+	}
+
+	n, err := NewSMP1Q(question, mpis...)
+	if err != nil {
+		return err
+	}
+
+	*m = *n
+	return nil
+}
+
+// MarshalBinary encodes the message in the binary format read by
+// UnmarshalMessage
+func (m SMP2) MarshalBinary() ([]byte, error) {
+	return marshalMessage(wireSMP2, "", m.MPIs())
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary
+func (m *SMP2) UnmarshalBinary(data []byte) error {
+	_, mpis, err := unmarshalMessage(data, wireSMP2)
+	if err != nil {
+		return err
+	}
+
+	n, err := NewSMP2(mpis...)
+	if err != nil {
+		return err
+	}
+
+	*m = *n
+	return nil
+}
+
+// MarshalBinary encodes the message in the binary format read by
+// UnmarshalMessage
+func (m SMP3) MarshalBinary() ([]byte, error) {
+	return marshalMessage(wireSMP3, "", m.MPIs())
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary
+func (m *SMP3) UnmarshalBinary(data []byte) error {
+	_, mpis, err := unmarshalMessage(data, wireSMP3)
+	if err != nil {
+		return err
+	}
+
+	n, err := NewSMP3(mpis...)
+	if err != nil {
+		return err
+	}
+
+	*m = *n
+	return nil
+}
+
+// MarshalBinary encodes the message in the binary format read by
+// UnmarshalMessage
+func (m SMP4) MarshalBinary() ([]byte, error) {
+	return marshalMessage(wireSMP4, "", m.MPIs())
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary
+func (m *SMP4) UnmarshalBinary(data []byte) error {
+	_, mpis, err := unmarshalMessage(data, wireSMP4)
+	if err != nil {
+		return err
+	}
+
+	n, err := NewSMP4(mpis...)
+	if err != nil {
+		return err
+	}
+
+	*m = *n
+	return nil
+}
+
+// MarshalBinary encodes the message in the binary format read by
+// UnmarshalMessage
+func (m SMPAbort) MarshalBinary() ([]byte, error) {
+	return marshalMessage(wireSMPAbort, "", nil)
+}
+
+// UnmarshalBinary decodes a message encoded by MarshalBinary
+func (m *SMPAbort) UnmarshalBinary(data []byte) error {
+	_, _, err := unmarshalMessage(data, wireSMPAbort)
+	return err
+}
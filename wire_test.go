@@ -0,0 +1,116 @@
+package smp
+
+import (
+	"bytes"
+	"encoding"
+	"errors"
+	"math/big"
+	"reflect"
+	"testing"
+)
+
+func TestMessagesRoundTripThroughTheBinaryFormat(t *testing.T) {
+	msgs := seededRun("alice wire seed", "bob wire seed")
+	q, _ := NewSMP1Q("what is the answer?", msgs[0].MPIs()...)
+	msgs = append(msgs, *q, SMPAbort{})
+
+	for _, m := range msgs {
+		data, err := m.(encoding.BinaryMarshaler).MarshalBinary()
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		got, err := UnmarshalMessage(data)
+		if err != nil {
+			t.Fatalf("%s: %v", messageType(m), err)
+		}
+
+		if !reflect.DeepEqual(got, m) {
+			t.Errorf("%s: decoded %#v", messageType(m), got)
+		}
+
+		// the concrete type decodes its own encoding
+		dest := reflect.New(reflect.TypeOf(m)).Interface().(encoding.BinaryUnmarshaler)
+		if err := dest.UnmarshalBinary(data); err != nil {
+			t.Errorf("%s: %v", messageType(m), err)
+		}
+
+		if !reflect.DeepEqual(reflect.ValueOf(dest).Elem().Interface(), m) {
+			t.Errorf("%s: UnmarshalBinary decoded %#v", messageType(m), dest)
+		}
+	}
+}
+
+func TestBinaryFormat(t *testing.T) {
+	abort, _ := SMPAbort{}.MarshalBinary()
+	if !bytes.Equal(abort, []byte{1, 6, 0, 0, 0, 0}) {
+		t.Errorf("unexpected SMPAbort encoding %x", abort)
+	}
+
+	m, _ := NewSMP4(big.NewInt(0x0102), big.NewInt(0), big.NewInt(3))
+	data, _ := m.MarshalBinary()
+	expected := []byte{
+		1, 5,
+		0, 0, 0, 3,
+		0, 0, 0, 2, 0x01, 0x02,
+		0, 0, 0, 0,
+		0, 0, 0, 1, 0x03,
+	}
+	if !bytes.Equal(data, expected) {
+		t.Errorf("unexpected SMP4 encoding %x", data)
+	}
+
+	q, _ := NewSMP1Q("?", big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1), big.NewInt(1))
+	data, _ = q.MarshalBinary()
+	if !bytes.HasPrefix(data, []byte{1, 7, 0, 0, 0, 1, '?', 0, 0, 0, 6}) {
+		t.Errorf("unexpected SMP1Q encoding %x", data)
+	}
+}
+
+func TestMalformedMessagesAreRejected(t *testing.T) {
+	smp4 := []byte{
+		1, 5,
+		0, 0, 0, 3,
+		0, 0, 0, 1, 0x01,
+		0, 0, 0, 1, 0x02,
+		0, 0, 0, 1, 0x03,
+	}
+
+	if _, err := UnmarshalMessage(smp4); err != nil {
+		t.Fatalf("valid message rejected: %v", err)
+	}
+
+	cases := map[string][]byte{
+		"empty":         {},
+		"version":       append([]byte{2}, smp4[1:]...),
+		"unknown type":  append([]byte{1, 9}, smp4[2:]...),
+		"count":         append([]byte{1, 5, 0, 0, 0, 2}, smp4[6:16]...),
+		"extra MPI":     append(append([]byte{1, 5, 0, 0, 0, 4}, smp4[6:]...), 0, 0, 0, 0),
+		"leading zero":  append(append([]byte{}, smp4[:16]...), 0, 0, 0, 2, 0x00, 0x03),
+		"truncated":     smp4[:len(smp4)-1],
+		"trailing data": append(append([]byte{}, smp4...), 0),
+		"no question":   {1, 7, 0, 0, 0},
+	}
+
+	for name, data := range cases {
+		_, err := UnmarshalMessage(data)
+
+		var me *MalformedMessageError
+		if !errors.As(err, &me) {
+			t.Errorf("%s: expected a MalformedMessageError, got %v", name, err)
+		}
+	}
+
+	var m SMP3
+	if err := m.UnmarshalBinary(smp4); err == nil {
+		t.Errorf("SMP3 should not decode an SMP4")
+	}
+}
+
+func TestZeroValueMessagesAreNotEncoded(t *testing.T) {
+	for _, m := range []encoding.BinaryMarshaler{SMP1{}, SMP1Q{}, SMP2{}, SMP3{}, SMP4{}} {
+		if _, err := m.MarshalBinary(); err != errMissingMPI {
+			t.Errorf("%T: expected %v, got %v", m, errMissingMPI, err)
+		}
+	}
+}
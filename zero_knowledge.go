@@ -1,47 +1,90 @@
 package smp
 
-import "math/big"
+import (
+	"hash"
+	"math/big"
+)
 
-func verifyZKP(d, gen, c *big.Int, ix byte) bool {
-	r := modExp(G1, d)
-	s := modExp(gen, c)
-	t := hashMPIsBN(nil, ix, mulMod(r, s, P))
+func verifyZKP(g Group, h proofHash, d, gen, c *big.Int, ix byte) bool {
+	r := multiExp(g, []*big.Int{g.Generator(), gen}, []*big.Int{d, c})
+	t := hashElements(g, h, ix, r)
 	return eq(c, t)
 }
 
-func generateZKP(r, a *big.Int, ix byte) (c, d *big.Int) {
-	c = hashMPIsBN(nil, ix, modExp(G1, r))
-	d = generateDZKP(r, a, c)
+func generateZKP(g Group, h proofHash, r, a *big.Int, ix byte) (c, d *big.Int) {
+	c = hashElements(g, h, ix, expSecret(g, g.Generator(), r))
+	d = generateDZKP(g, r, a, c)
 	return
 }
 
-func generateDZKP(r, a, c *big.Int) *big.Int {
-	return subMod(r, mul(a, c), Q)
+func generateDZKP(g Group, r, a, c *big.Int) *big.Int {
+	return subMod(r, mul(a, c), g.Order())
 }
 
-func verifyZKP2(g2, g3, d5, d6, pb, qb, cp *big.Int, ix byte) bool {
-	l := mulMod(
-		modExp(g3, d5),
-		modExp(pb, cp),
-		P)
-	r := mulMod(mul(modExp(G1, d5),
-		modExp(g2, d6)),
-		modExp(qb, cp),
-		P)
-	t := hashMPIsBN(nil, ix, l, r)
+func verifyZKP2(g Group, h proofHash, g2, g3, d5, d6, pb, qb, cp *big.Int, ix byte) bool {
+	l := multiExp(g, []*big.Int{g3, pb}, []*big.Int{d5, cp})
+	r := multiExp(g, []*big.Int{g.Generator(), g2, qb}, []*big.Int{d5, d6, cp})
+	t := hashElements(g, h, ix, l, r)
 	return eq(cp, t)
 }
 
-func verifyZKP3(cp, g2, g3, d5, d6, pa, qa *big.Int, ix byte) bool {
-	l := mulMod(modExp(g3, d5), modExp(pa, cp), P)
-	r := mulMod(mul(modExp(G1, d5), modExp(g2, d6)), modExp(qa, cp), P)
-	t := hashMPIsBN(nil, ix, l, r)
+func verifyZKP3(g Group, h proofHash, cp, g2, g3, d5, d6, pa, qa *big.Int, ix byte) bool {
+	l := multiExp(g, []*big.Int{g3, pa}, []*big.Int{d5, cp})
+	r := multiExp(g, []*big.Int{g.Generator(), g2, qa}, []*big.Int{d5, d6, cp})
+	t := hashElements(g, h, ix, l, r)
 	return eq(cp, t)
 }
 
-func verifyZKP4(cr, g3a, d7, qaqb, ra *big.Int, ix byte) bool {
-	l := mulMod(modExp(G1, d7), modExp(g3a, cr), P)
-	r := mulMod(modExp(qaqb, d7), modExp(ra, cr), P)
-	t := hashMPIsBN(nil, ix, l, r)
+func verifyZKP4(g Group, h proofHash, cr, g3a, d7, qaqb, ra *big.Int, ix byte) bool {
+	l := multiExp(g, []*big.Int{g.Generator(), g3a}, []*big.Int{d7, cr})
+	r := multiExp(g, []*big.Int{qaqb, ra}, []*big.Int{d7, cr})
+	t := hashElements(g, h, ix, l, r)
 	return eq(cr, t)
 }
+
+// verifyProofs runs the checks in order, and returns the index of the first
+// one that fails, or -1. The checks after a failure are not run.
+//
+// The proofs of SMP can't be batched with random linear combinations: a
+// proof (c, d) carries the hash c of its commitment instead of the commitment
+// itself, so every commitment must be recomputed and hashed on its own.
+func verifyProofs(checks ...func() bool) int {
+	for i, check := range checks {
+		if !check() {
+			return i
+		}
+	}
+
+	return -1
+}
+
+// ProofHasher is implemented by groups that define their own hash for the
+// zero knowledge proofs. Other groups hash the MPI encoding of the group
+// elements with the proofHash of the protocol.
+type ProofHasher interface {
+	HashToScalar(ix byte, elements ...*big.Int) *big.Int
+}
+
+// proofHash is the hash of the zero knowledge proofs and its domain
+// separation prefix. The zero value is SHA-256 without prefix, as defined by
+// OTRv3.
+type proofHash struct {
+	new    func() hash.Hash
+	prefix []byte
+}
+
+func (h proofHash) hash() hash.Hash {
+	if h.new == nil {
+		return nil
+	}
+
+	return h.new()
+}
+
+func hashElements(g Group, h proofHash, ix byte, elements ...*big.Int) *big.Int {
+	if ph, ok := g.(ProofHasher); ok {
+		return ph.HashToScalar(ix, elements...)
+	}
+
+	return hashMPIsBN(h.hash(), h.prefix, ix, elements...)
+}
@@ -0,0 +1,163 @@
+package smp
+
+import (
+	"crypto/rand"
+	"math/big"
+	"testing"
+)
+
+// separateExp hides the MultiExponentiator of a group
+type separateExp struct {
+	Group
+}
+
+func TestMultiExpMatchesSeparateExp(t *testing.T) {
+	g := MODP1536
+	bases := []*big.Int{g.Generator(), g.Exp(g.Generator(), big.NewInt(3)), g.Exp(g.Generator(), big.NewInt(5))}
+	exps := []*big.Int{big.NewInt(7), big.NewInt(11), big.NewInt(13)}
+
+	// 7 + 3*11 + 5*13
+	expected := g.Exp(g.Generator(), big.NewInt(105))
+	if got := multiExp(g, bases, exps); !eq(got, expected) {
+		t.Errorf("got %x, expected %x", got, expected)
+	}
+
+	for i := 0; i < 10; i++ {
+		bases, exps := randomTerms(g, 3)
+		if !eq(multiExp(g, bases, exps), multiExp(separateExp{g}, bases, exps)) {
+			t.Fatalf("MultiExp differs from separate exponentiations")
+		}
+	}
+
+	// the exponents are reduced, as in Exp
+	bases, exps = randomTerms(g, 2)
+	exps[1] = new(big.Int).Add(exps[1], g.Order())
+	if !eq(multiExp(g, bases, exps), multiExp(separateExp{g}, bases, exps)) {
+		t.Errorf("MultiExp differs for an exponent larger than the order")
+	}
+}
+
+// randomTerms returns n random elements of g and n random exponents
+func randomTerms(g Group, n int) (bases, exps []*big.Int) {
+	for i := 0; i < n; i++ {
+		x, _ := rand.Int(rand.Reader, g.Order())
+		e, _ := rand.Int(rand.Reader, g.Order())
+		bases = append(bases, g.Exp(g.Generator(), x))
+		exps = append(exps, e)
+	}
+
+	return
+}
+
+// BenchmarkMultiExp compares the products of the proof of SMP2, like
+// g^d5 * g2^d6 * qb^cp, computed by MultiExp and by separate exponentiations
+func BenchmarkMultiExp(b *testing.B) {
+	g := MODP1536
+	bases, exps := randomTerms(g, 3)
+	bases[0] = g.Generator()
+	exps[2] = new(big.Int).Rsh(exps[2], uint(g.Order().BitLen()-256))
+
+	b.Run("multi", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			multiExp(g, bases, exps)
+		}
+	})
+
+	b.Run("separate", func(b *testing.B) {
+		for n := 0; n < b.N; n++ {
+			multiExp(separateExp{g}, bases, exps)
+		}
+	})
+}
+
+func TestVerifyProofsReturnsTheFirstFailure(t *testing.T) {
+	pass := func() bool { return true }
+	fail := func() bool { return false }
+	unreachable := func() bool {
+		t.Errorf("the checks after a failure should not run")
+		return false
+	}
+
+	if i := verifyProofs(pass, pass, pass); i != -1 {
+		t.Errorf("expected -1, got %d", i)
+	}
+
+	if i := verifyProofs(pass, fail, unreachable); i != 1 {
+		t.Errorf("expected 1, got %d", i)
+	}
+}
+
+// verifyFixture holds a run of the protocol, and the peers in the state
+// each message is verified in
+type verifyFixture struct {
+	msgs      []Message
+	receivers []*Protocol
+}
+
+func newVerifyFixture(t testing.TB) *verifyFixture {
+	alice := NewProtocol(DefaultOptions())
+	alice.Secret = big.NewInt(42)
+	bob := NewProtocol(DefaultOptions())
+	bob.Secret = big.NewInt(42)
+
+	m1, _ := alice.Compare()
+	m2, _ := bob.Receive(m1)
+	m3, _ := alice.Receive(m2)
+
+	// bob is wiped once he replies to SMP3, so verify SMP3 with a copy
+	snapshot, err := bob.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	bob3 := NewProtocol(DefaultOptions())
+	if err := bob3.UnmarshalBinary(snapshot); err != nil {
+		t.Fatal(err)
+	}
+
+	m4, _ := bob.Receive(m3)
+
+	return &verifyFixture{
+		msgs:      []Message{derefMessage(m1), derefMessage(m2), derefMessage(m3), derefMessage(m4)},
+		receivers: []*Protocol{bob, alice, bob3, alice},
+	}
+}
+
+func (f *verifyFixture) verify(i int) error {
+	p := f.receivers[i]
+	switch m := f.msgs[i].(type) {
+	case SMP1:
+		return p.verifySMP1(m)
+	case SMP2:
+		return p.verifySMP2(m)
+	case SMP3:
+		return p.verifySMP3(m)
+	case SMP4:
+		return p.verifySMP4(m)
+	}
+
+	return nil
+}
+
+func TestVerifyFixture(t *testing.T) {
+	f := newVerifyFixture(t)
+	for i := range f.msgs {
+		if err := f.verify(i); err != nil {
+			t.Errorf("SMP%d: %v", i+1, err)
+		}
+	}
+}
+
+func BenchmarkVerify(b *testing.B) {
+	f := newVerifyFixture(b)
+
+	for i, name := range []string{"SMP1", "SMP2", "SMP3", "SMP4"} {
+		b.Run(name, func(b *testing.B) {
+			for n := 0; n < b.N; n++ {
+				if err := f.verify(i); err != nil {
+					b.Fatal(err)
+				}
+			}
+		})
+	}
+}